@@ -0,0 +1,35 @@
+//go:build !race
+
+package docopt
+
+import "testing"
+
+// TestParseAllocationBudget guards against regressions of the hot paths.
+// Budgets have some headroom over the measured allocations, which the race
+// detector and coverage instrumentation increase: see also BenchmarkParseDoc.
+func TestParseAllocationBudget(t *testing.T) {
+	if testing.CoverMode() != "" {
+		t.Skip("allocations are not measured with coverage")
+	}
+	for _, tc := range []struct {
+		name   string
+		doc    string
+		argv   []string
+		budget float64
+	}{
+		{"naval_fate", navalFateDoc, []string{"ship", "Guardian", "move", "10", "50"}, 800},
+		{"git_branch", gitBranchDoc, []string{"branch", "-v", "-d", "topic"}, 1250},
+		{"options_200", manyOptionsDoc(200), []string{"--flag-42", "a"}, 4700},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			allocs := testing.AllocsPerRun(10, func() {
+				if _, err := testParser.Parse(tc.doc, tc.argv, ""); err != nil {
+					t.Fatal(err)
+				}
+			})
+			if allocs > tc.budget {
+				t.Errorf("allocations: have %v; want <= %v", allocs, tc.budget)
+			}
+		})
+	}
+}
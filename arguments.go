@@ -0,0 +1,97 @@
+package docopt
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Argument is a positional argument described in the arguments sections of
+// a doc.
+type Argument struct {
+	// Name is the name of the argument, like "<host>" or "HOST".
+	Name string
+	// Description is the description, with white space normalized.
+	Description string
+	// Default is the [default: ...] value, if any: the value in Opts when
+	// the argument is not given. For a repeated argument (<file>...) it is
+	// split on white space.
+	Default string
+}
+
+var (
+	reArgumentsHeading    = regexp.MustCompile(`(?i)arguments:`)
+	reArgumentDescription = regexp.MustCompile(`(?m)\n[ \t]*(<[^>\s]+>|[A-Z][A-Z0-9_-]*)(?:  |[ \t]*$)`)
+)
+
+// Arguments returns the positional arguments described in the arguments
+// sections of doc, in order. An arguments section is a section whose
+// heading contains "arguments:", with an argument per line followed by its
+// description, after at least two spaces:
+//
+//	Arguments:
+//	  <host>  Target host [default: localhost].
+func Arguments(doc string) []Argument {
+	var arguments []Argument
+	for _, desc := range argumentDescriptions(doc) {
+		name, description := splitOptionDescription(desc.text)
+		a := Argument{Name: name, Description: description}
+		if matched := reDefault.FindStringSubmatch(description); matched != nil {
+			a.Default = matched[1]
+		}
+		arguments = append(arguments, a)
+	}
+	return arguments
+}
+
+// argumentDescriptions returns the argument descriptions in the arguments
+// sections of doc, located in doc.
+func argumentDescriptions(doc string) []section {
+	var descs []section
+	for _, sect := range findSections("arguments:", doc) {
+		start := reArgumentsHeading.FindStringIndex(sect.text)[1]
+		s := "\n" + sect.text[start:]
+		locs := reArgumentDescription.FindAllStringSubmatchIndex(s, -1)
+		for i, loc := range locs {
+			end := len(s)
+			if i+1 < len(locs) {
+				end = locs[i+1][0]
+			}
+			descs = append(descs, section{s[loc[2]:end], sect.offset + start + loc[2] - 1})
+		}
+	}
+	return descs
+}
+
+// setArgumentDefaults sets in opts the default of the arguments of doc that
+// are in line, the usage line matched, and were not given.
+func setArgumentDefaults(opts Opts, doc string, line *pattern) {
+	// Look for the arguments sections only if needed: they are rare.
+	missing := false
+	line.walkLeaves(func(leaf *pattern) {
+		missing = missing || leaf.t&patternArgument != 0 && !given(opts[leaf.name])
+	})
+	if !missing {
+		return
+	}
+	for _, a := range Arguments(doc) {
+		if a.Default == "" || !line.hasLeaf(patternArgument, a.Name) || given(opts[a.Name]) {
+			continue
+		}
+		if _, repeated := opts[a.Name].([]string); repeated {
+			opts[a.Name] = strings.Fields(a.Default)
+		} else {
+			opts[a.Name] = a.Default
+		}
+	}
+}
+
+// given reports whether v, the value of an argument in Opts, was given.
+func given(v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case []string:
+		return len(v) > 0
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package docopt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-quicktest/qt"
+)
+
+const argumentsDoc = `Usage: prog [<host>] [<port>] [FILE...]
+
+Arguments:
+  <host>  Target host [default: localhost].
+  <port>  Target port [default: 80].
+  FILE    Files to send, a long description
+          on two lines [default: a.txt b.txt].`
+
+func TestArguments(t *testing.T) {
+	qt.Assert(t, qt.DeepEquals(Arguments(argumentsDoc), []Argument{
+		{"<host>", "Target host [default: localhost].", "localhost"},
+		{"<port>", "Target port [default: 80].", "80"},
+		{"FILE", "Files to send, a long description on two lines [default: a.txt b.txt].", "a.txt b.txt"},
+	}))
+}
+
+func TestArgumentDefaults(t *testing.T) {
+	v, err := testParser.Parse(argumentsDoc, []string{}, "")
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(v, Opts{"<host>": "localhost", "<port>": "80", "FILE": []string{"a.txt", "b.txt"}}))
+
+	v, err = testParser.Parse(argumentsDoc, []string{"example.com", "8080", "c.txt"}, "")
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(v, Opts{"<host>": "example.com", "<port>": "8080", "FILE": []string{"c.txt"}}))
+
+	var cfg struct {
+		Host string
+		Port int
+		File []string
+	}
+	v, err = testParser.Parse(argumentsDoc, []string{"example.com"}, "")
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.IsNil(v.Bind(&cfg)))
+	qt.Assert(t, qt.Equals(cfg.Host, "example.com"))
+	qt.Assert(t, qt.Equals(cfg.Port, 80))
+	qt.Assert(t, qt.DeepEquals(cfg.File, []string{"a.txt", "b.txt"}))
+}
+
+func TestArgumentSingleLetter(t *testing.T) {
+	doc := `Usage: prog [N] [M]
+
+Arguments:
+  N  Number of times, a long description
+     A continuation [default: 3].
+  M  Margin [default: 1].`
+	qt.Assert(t, qt.DeepEquals(Arguments(doc), []Argument{
+		{"N", "Number of times, a long description A continuation [default: 3].", "3"},
+		{"M", "Margin [default: 1].", "1"},
+	}))
+	v, err := testParser.Parse(doc, []string{}, "")
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(v, Opts{"N": "3", "M": "1"}))
+}
+
+func TestArgumentDefaultsOfMatchedLine(t *testing.T) {
+	doc := `Usage:
+  prog get [<key>]
+  prog list [<dir>]
+
+Arguments:
+  <key>  Key to get [default: all].
+  <dir>  Directory to list [default: .].`
+	v, err := testParser.Parse(doc, []string{"list"}, "")
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(v, Opts{"get": false, "list": true, "<key>": nil, "<dir>": "."}))
+
+	v, err = testParser.Parse(doc, []string{"get"}, "")
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(v, Opts{"get": true, "list": false, "<key>": "all", "<dir>": nil}))
+}
+
+func TestFormatHelpArguments(t *testing.T) {
+	parser := &Parser{FormatHelp: true, HelpWidth: 60}
+	help, err := parser.Help(argumentsDoc + "\n\nOptions:\n  --timeout=<secs>  Timeout.")
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(help, strings.TrimSpace(`
+Usage: prog [<host>] [<port>] [FILE...]
+
+Arguments:
+  <host>            Target host [default: localhost].
+  <port>            Target port [default: 80].
+  FILE              Files to send, a long description on two
+                    lines [default: a.txt b.txt].
+
+Options:
+  --timeout=<secs>  Timeout.`)))
+}
@@ -0,0 +1,238 @@
+package docopt
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// alternativesUsage returns a usage with n optional groups of alternatives,
+// whose expansion by transform has 2^n cases.
+func alternativesUsage(n int) string {
+	var b strings.Builder
+	b.WriteString("usage: prog")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, " [--a%d | --b%d]", i, i)
+	}
+	b.WriteString(" <file>...")
+	return b.String()
+}
+
+func BenchmarkFixRepeatingArguments(b *testing.B) {
+	for _, n := range []int{4, 8, 12} {
+		pat := usagePattern(b, alternativesUsage(n))
+		b.Run(fmt.Sprintf("groups=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				pat.fixRepeatingArguments()
+			}
+		})
+	}
+}
+
+// BenchmarkFixRepeatingArgumentsTransform is the baseline for
+// BenchmarkFixRepeatingArguments.
+func BenchmarkFixRepeatingArgumentsTransform(b *testing.B) {
+	for _, n := range []int{4, 8, 12} {
+		pat := usagePattern(b, alternativesUsage(n))
+		b.Run(fmt.Sprintf("groups=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				fixRepeatingArgumentsTransform(pat)
+			}
+		})
+	}
+}
+
+func BenchmarkParseManyAlternatives(b *testing.B) {
+	doc := alternativesUsage(32)
+	argv := []string{"--a3", "--b17", "x", "y"}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := testParser.Parse(doc, argv, ""); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+const navalFateDoc = `Naval Fate.
+
+Usage:
+  naval_fate ship new <name>...
+  naval_fate ship <name> move <x> <y> [--speed=<kn>]
+  naval_fate ship shoot <x> <y>
+  naval_fate mine (set|remove) <x> <y> [--moored|--drifting]
+  naval_fate -h | --help
+  naval_fate --version
+
+Options:
+  -h --help     Show this screen.
+  --version     Show version.
+  --speed=<kn>  Speed in knots [default: 10].
+  --moored      Moored (anchored) mine.
+  --drifting    Drifting mine.`
+
+// From examples/fake-git.
+const gitDoc = `usage: git [--version] [--exec-path=<path>] [--html-path]
+           [-p|--paginate|--no-pager] [--no-replace-objects]
+           [--bare] [--git-dir=<path>] [--work-tree=<path>]
+           [-c <name=value>]... [--help]
+           <command> [<args>...]
+
+options:
+   -c <name=value>  Pass a configuration parameter to the command [map]
+   -h, --help
+   -p, --paginate
+
+The most commonly used git commands are:
+   add        Add file contents to the index
+   branch     List, create, or delete branches
+   checkout   Checkout a branch or paths to the working tree
+   clone      Clone a repository into a new directory
+   commit     Record changes to the repository
+   push       Update remote refs along with associated objects
+   remote     Manage set of tracked repositories
+
+See 'git help <command>' for more information on a specific command.
+`
+
+// From examples/fake-git.
+const gitAddDoc = `usage: git add [options] [--] [<filepattern>...]
+
+options:
+	-h, --help
+	-n, --dry-run        dry run
+	-v, --verbose        be verbose
+	-i, --interactive    interactive picking
+	-p, --patch          select hunks interactively
+	-e, --edit           edit current diff and apply
+	-f, --force          allow adding otherwise ignored files
+	-u, --update         update tracked files
+	-N, --intent-to-add  record only the fact that the path will be added later
+	-A, --all            add all, noticing removal of tracked files
+	--refresh            don't add, only refresh the index
+	--ignore-errors      just skip files which cannot be added because of errors
+	--ignore-missing     check if - even missing - files are ignored in dry run
+`
+
+// From examples/fake-git/branch.
+const gitBranchDoc = `usage: git branch [options] [-r | -a] [--merged=<commit> | --no-merged=<commit>]
+       git branch [options] [-l] [-f] <branchname> [<start-point>]
+       git branch [options] [-r] (-d | -D) <branchname>
+       git branch [options] (-m | -M) [<oldbranch>] <newbranch>
+
+Generic options:
+    -h, --help
+    -v, --verbose         show hash and subject, give twice for upstream branch
+    -t, --track           set up tracking mode (see git-pull(1))
+    --set-upstream        change upstream info
+    --color=<when>        use colored output
+    -r                    act on remote-tracking branches
+    --contains=<commit>   print only branches that contain the commit
+    --abbrev=<n>          use <n> digits to display SHA-1s
+
+Specific git-branch actions:
+    -a                    list both remote-tracking and local branches
+    -d                    delete fully merged branch
+    -D                    delete branch (even if not merged)
+    -m                    move/rename a branch and its reflog
+    -M                    move/rename a branch, even if target exists
+    -l                    create the branch's reflog
+    -f, --force           force creation (when already exists)
+    --no-merged=<commit>  print only not merged branches
+    --merged=<commit>     print only merged branches
+`
+
+// manyOptionsDoc returns a doc with n options, half of which take an argument.
+func manyOptionsDoc(n int) string {
+	var b strings.Builder
+	b.WriteString("Usage: prog [options] <file>...\n\nOptions:\n")
+	for i := 0; i < n; i++ {
+		if i%2 == 0 {
+			fmt.Fprintf(&b, "  --flag-%d        Flag number %d.\n", i, i)
+		} else {
+			fmt.Fprintf(&b, "  --value-%d=<v>   Value number %d [default: %d].\n", i, i, i)
+		}
+	}
+	return b.String()
+}
+
+func BenchmarkParseDoc(b *testing.B) {
+	optionsFirst := &Parser{OptionsFirst: true}
+	for _, bm := range []struct {
+		name   string
+		parser *Parser
+		doc    string
+		argv   []string
+	}{
+		{"naval_fate", testParser, navalFateDoc, []string{"ship", "Guardian", "move", "10", "50", "--speed=20"}},
+		{"git", optionsFirst, gitDoc, []string{"-p", "--bare", "commit", "-m", "msg"}},
+		{"git_add", testParser, gitAddDoc, []string{"add", "-v", "-n", "a.txt", "b.txt"}},
+		{"git_branch", testParser, gitBranchDoc, []string{"branch", "-v", "-d", "topic"}},
+		{"options_200", testParser, manyOptionsDoc(200), []string{"--flag-42", "--value-7=x", "a"}},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := bm.parser.Parse(bm.doc, bm.argv, ""); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMatchArgv(b *testing.B) {
+	doc := `Usage: prog [-abc] [-v...] [--verbose] [--file=<f>] [--name=<n>...] [<x>...]`
+	for _, bm := range []struct {
+		name string
+		argv []string
+	}{
+		{"short", []string{"-a", "-bc", "-vv"}},
+		{"long", []string{"--verbose", "--file=f.txt", "--name", "a"}},
+		{"repeated", []string{
+			"-vvvvvvvv", "--name=a", "--name=b", "--name=c", "--name=d",
+			"x1", "x2", "x3", "x4", "x5", "x6", "x7", "x8",
+		}},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := testParser.Parse(doc, bm.argv, ""); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkBind(b *testing.B) {
+	opts, err := testParser.Parse(navalFateDoc,
+		[]string{"ship", "Guardian", "move", "10", "50", "--speed=20"}, "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var conf struct {
+			Ship     bool
+			New      bool
+			Name     []string
+			Move     bool
+			X        int
+			Y        int
+			Speed    int
+			Shoot    bool
+			Mine     bool
+			Set      bool
+			Remove   bool
+			Moored   bool
+			Drifting bool
+			Help     bool
+			Version  bool
+		}
+		if err := opts.Bind(&conf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package docopt
+
+import (
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Color is when a Parser colorizes its output.
+type Color int
+
+const (
+	// ColorNever never colorizes. This is the default.
+	ColorNever Color = iota
+	// ColorAuto colorizes when writing to a terminal, unless the environment
+	// variable NO_COLOR is set and not empty. If FORCE_COLOR is set and not
+	// empty, it colorizes also when not writing to a terminal.
+	ColorAuto
+	// ColorAlways always colorizes.
+	ColorAlways
+)
+
+// ANSI escape sequences of the styles.
+const (
+	styleReset    = "\x1b[0m"
+	styleHeading  = "\x1b[1m"  // bold
+	styleCommand  = "\x1b[32m" // green
+	styleOption   = "\x1b[36m" // cyan
+	styleArgument = "\x1b[33m" // yellow
+	styleDefault  = "\x1b[2m"  // faint
+	styleError    = "\x1b[31m" // red
+)
+
+// color reports whether to colorize the output to w, see Parser.Color.
+func (p *Parser) color(w io.Writer) bool {
+	switch p.Color {
+	case ColorAlways:
+		return true
+	case ColorAuto:
+		if os.Getenv("FORCE_COLOR") != "" {
+			return true
+		}
+		return os.Getenv("NO_COLOR") == "" && isTerminal(w)
+	}
+	return false
+}
+
+// isTerminal reports whether w is a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// span is a part of a text to style.
+type span struct {
+	start, end int
+	style      string
+}
+
+// The option names and arguments in an option description, like -o, --output
+// and FILE in "-o FILE, --output=FILE".
+var reOptionNames = regexp.MustCompile(`(?:\[no-\]|[^\s,=\[\]])+`)
+
+// colorize returns doc, as parsed, with the section headings, the program
+// name, commands, arguments, options and defaults highlighted.
+func (p *Parser) colorize(doc string) string {
+	var spans []span
+	if usages := findSections("usage:", doc); len(usages) == 1 {
+		spans = append(spans, p.usageSpans(doc, usages[0])...)
+	}
+	for _, sect := range findSections("options:", doc) {
+		spans = append(spans, span{sect.offset, sect.offset + optionsHeading(sect.text), styleHeading})
+	}
+	for _, desc := range p.descriptions(doc) {
+		names, _, _ := stringPartition(desc.text, "  ")
+		for _, loc := range reOptionNames.FindAllStringIndex(names, -1) {
+			style := styleArgument
+			if p.isOption(names[loc[0]:loc[1]]) {
+				style = styleOption
+			}
+			spans = append(spans, span{desc.offset + loc[0], desc.offset + loc[1], style})
+		}
+		if loc := reDefault.FindStringIndex(desc.text); loc != nil && loc[0] >= len(names) {
+			spans = append(spans, span{desc.offset + loc[0], desc.offset + loc[1], styleDefault})
+		}
+	}
+	return applySpans(doc, spans)
+}
+
+// usageSpans returns the spans of the usage section of doc.
+func (p *Parser) usageSpans(doc string, usage section) []span {
+	heading := strings.Index(usage.text, ":") + 1
+	spans := []span{{usage.offset, usage.offset + heading, styleHeading}}
+	fields, offsets := fieldsOffsets(usage.text[heading:])
+	for i, field := range fields {
+		if field == fields[0] {
+			start := usage.offset + heading + offsets[i]
+			spans = append(spans, span{start, start + len(field), styleHeading})
+		}
+	}
+	tokens, err := tokenListFromUsage(doc, usage)
+	if err != nil {
+		return spans
+	}
+	for i, tok := range tokens.tokens {
+		start := tokens.offsets[i]
+		if !strings.HasPrefix(doc[start:], tok) {
+			continue // the token spans white space, like "<a  b>"
+		}
+		switch {
+		case slices.Contains([]string{"[", "]", "(", ")", "|", "...", "--"}, tok):
+		case tok == "options" || p.isOption(tok):
+			// The name and the argument of --name=<arg> apart.
+			name := tok
+			if i := strings.IndexAny(tok, "=["); i > 0 {
+				name = tok[:i]
+			}
+			spans = append(spans, span{start, start + len(name), styleOption})
+			if j, k := strings.IndexByte(tok, '<'), strings.LastIndexByte(tok, '>'); j > 0 && k > j {
+				spans = append(spans, span{start + j, start + k + 1, styleArgument})
+			}
+		case strings.HasPrefix(tok, "<") && strings.HasSuffix(tok, ">") || isStringUppercase(tok):
+			spans = append(spans, span{start, start + len(tok), styleArgument})
+		default:
+			spans = append(spans, span{start, start + len(tok), styleCommand})
+		}
+	}
+	return spans
+}
+
+// applySpans returns text with the spans styled, skipping overlapping ones.
+func applySpans(text string, spans []span) string {
+	slices.SortStableFunc(spans, func(a, b span) int { return a.start - b.start })
+	var b strings.Builder
+	prev := 0
+	for _, sp := range spans {
+		if sp.start < prev || sp.end <= sp.start {
+			continue
+		}
+		b.WriteString(text[prev:sp.start])
+		b.WriteString(sp.style + text[sp.start:sp.end] + styleReset)
+		prev = sp.end
+	}
+	b.WriteString(text[prev:])
+	return b.String()
+}
+
+// colorizeError returns the output of a usage error err, the error followed
+// by the usage, with the error highlighted and the usage colorized.
+func (p *Parser) colorizeError(output string, err error) string {
+	msg := err.Error()
+	if !strings.HasPrefix(output, msg) {
+		return p.colorize(output)
+	}
+	return styleError + msg + styleReset + p.colorize(output[len(msg):])
+}
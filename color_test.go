@@ -0,0 +1,75 @@
+package docopt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-quicktest/qt"
+)
+
+// styled replaces in s the markers {h}, {c}, {o}, {a}, {d}, {e} with the
+// styles and {/} with the reset.
+func styled(s string) string {
+	return strings.NewReplacer(
+		"{h}", styleHeading,
+		"{c}", styleCommand,
+		"{o}", styleOption,
+		"{a}", styleArgument,
+		"{d}", styleDefault,
+		"{e}", styleError,
+		"{/}", styleReset,
+	).Replace(s)
+}
+
+const colorDoc = `Usage:
+  prog ship <name> [--speed=<kn>]
+  prog -h | --help
+
+Options:
+  -h, --help    Show help.
+  --speed=<kn>  Speed [default: 10].`
+
+func TestColorizeHelp(t *testing.T) {
+	var stdout strings.Builder
+	parser := &Parser{Color: ColorAlways, Stdout: &stdout}
+
+	_, err := parser.Parse(colorDoc, []string{"--help"}, "")
+
+	qt.Assert(t, qt.ErrorIs(err, ErrHelp))
+	qt.Assert(t, qt.Equals(stdout.String(), styled(`{h}Usage:{/}
+  {h}prog{/} {c}ship{/} {a}<name>{/} [{o}--speed{/}={a}<kn>{/}]
+  {h}prog{/} {o}-h{/} | {o}--help{/}
+
+{h}Options:{/}
+  {o}-h{/}, {o}--help{/}    Show help.
+  {o}--speed{/}={a}<kn>{/}  Speed {d}[default: 10]{/}.
+`)))
+}
+
+func TestColorizeError(t *testing.T) {
+	var stderr strings.Builder
+	parser := &Parser{Color: ColorAlways, Stderr: &stderr}
+
+	_, err := parser.Parse("Usage: prog <name>", []string{"-x"}, "")
+
+	qt.Assert(t, qt.ErrorAs(err, new(*UserError)))
+	qt.Assert(t, qt.Equals(stderr.String(), styled("{e}unknown option: -x{/}\n{h}Usage:{/} {h}prog{/} {a}<name>{/}\n")))
+}
+
+func TestColorWhen(t *testing.T) {
+	var out strings.Builder
+	t.Setenv("NO_COLOR", "")
+	t.Setenv("FORCE_COLOR", "")
+
+	qt.Assert(t, qt.IsFalse((&Parser{}).color(&out)))
+	qt.Assert(t, qt.IsTrue((&Parser{Color: ColorAlways}).color(&out)))
+	qt.Assert(t, qt.IsFalse((&Parser{Color: ColorAuto}).color(&out)))
+
+	t.Setenv("FORCE_COLOR", "1")
+	qt.Assert(t, qt.IsTrue((&Parser{Color: ColorAuto}).color(&out)))
+	qt.Assert(t, qt.IsFalse((&Parser{Color: ColorNever}).color(&out)))
+
+	t.Setenv("FORCE_COLOR", "")
+	t.Setenv("NO_COLOR", "1")
+	qt.Assert(t, qt.IsFalse((&Parser{Color: ColorAuto}).color(&out)))
+}
@@ -0,0 +1,248 @@
+package docopt
+
+import (
+	"slices"
+	"strings"
+)
+
+// Command is a command of a doc, with its description.
+type Command struct {
+	// Name is the name of the command, like "ship".
+	Name string
+	// Description is the description, with white space normalized; empty if
+	// the doc does not describe the command.
+	Description string
+}
+
+// Commands returns the commands of doc: first the ones of the usage, in
+// order, then the other ones described in the commands sections, like the
+// commands dispatched on an argument <command>.
+//
+// A commands section is a section whose heading contains "commands", like
+// "Commands:", with a command per line followed by its description, after
+// at least two spaces; more indented lines continue the description:
+//
+//	Commands:
+//	  tcp     Connect via TCP.
+//	  serial  Connect via serial.
+//
+// The error, a LanguageError, is for an invalid usage or options section.
+func (p *Parser) Commands(doc string) ([]Command, error) {
+	described := commandDescriptions(doc)
+	var commands []Command
+	seen := make(map[string]bool)
+	if usages := findSections("usage:", doc); len(usages) == 1 {
+		options, err := p.parseDefaults(doc)
+		if err != nil {
+			return nil, err
+		}
+		tokens, err := tokenListFromUsage(doc, usages[0])
+		if err != nil {
+			return nil, err
+		}
+		pat, err := p.parseTokens(tokens, &options)
+		if err != nil {
+			return nil, err
+		}
+		leaves, err := pat.flat(patternCommand)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range leaves {
+			if !seen[c.name] {
+				commands = append(commands, Command{c.name, descriptionOf(described, c.name)})
+				seen[c.name] = true
+			}
+		}
+	}
+	for _, c := range described {
+		if !seen[c.Name] {
+			commands = append(commands, c)
+			seen[c.Name] = true
+		}
+	}
+	return commands, nil
+}
+
+// SuggestCommand returns the command of doc closest to name, as in "did you
+// mean ...?", or "" if none is close enough. Parse suggests so for the
+// commands of the usage; programs dispatching on an argument <command> can
+// use it to report an unknown command. The doc is assumed valid, as checked
+// by Parse: for an invalid doc, SuggestCommand returns "".
+func (p *Parser) SuggestCommand(doc, name string) string {
+	commands, _ := p.Commands(doc)
+	var names []string
+	for _, c := range commands {
+		names = append(names, c.Name)
+	}
+	return suggest(names, name)
+}
+
+// commandDescriptions returns the commands described in the commands
+// sections of doc.
+func commandDescriptions(doc string) []Command {
+	var commands []Command
+	for _, sect := range Sections(doc) {
+		if !strings.Contains(strings.ToLower(sect.Name), "commands") {
+			continue
+		}
+		_, _, body := stringPartition(sect.Text, ":")
+		indent := -1
+		for _, line := range strings.Split(body, "\n") {
+			text := strings.TrimSpace(line)
+			if text == "" {
+				continue
+			}
+			lineIndent := len(line) - len(strings.TrimLeft(line, " \t"))
+			if indent < 0 {
+				indent = lineIndent
+			}
+			if lineIndent > indent && len(commands) > 0 {
+				// continuation of the description
+				last := &commands[len(commands)-1]
+				last.Description = strings.TrimSpace(last.Description + " " + strings.Join(strings.Fields(text), " "))
+				continue
+			}
+			name, _, description := stringPartition(text, "  ")
+			commands = append(commands, Command{name, strings.Join(strings.Fields(description), " ")})
+		}
+	}
+	return commands
+}
+
+func descriptionOf(commands []Command, name string) string {
+	for _, c := range commands {
+		if c.Name == name {
+			return c.Description
+		}
+	}
+	return ""
+}
+
+// errUnknownCommand returns the error for the first argument of left, the
+// part of argv not matched by pat, that is where pat accepts commands but
+// is not one of them, while close to one; or nil.
+func (p *Parser) errUnknownCommand(pat *pattern, argv, left patternList) error {
+	var positionals patternList
+	for _, a := range argv {
+		if a.t&patternArgument != 0 {
+			positionals = append(positionals, a)
+		}
+	}
+	commands := pat.commandsAt(len(positionals))
+	for _, a := range left {
+		i := slices.Index(positionals, a)
+		arg, ok := a.value.(string)
+		if i < 0 || !ok || slices.Contains(commands[i], arg) {
+			continue
+		}
+		if s := suggest(commands[i], arg); s != "" {
+			return &UserError{p.message(MsgUnknownCommand, arg, s)}
+		}
+	}
+	return nil
+}
+
+// commandsAt returns the commands that p accepts at each of the first n
+// positions of the positional arguments.
+func (p *pattern) commandsAt(n int) [][]string {
+	commands := make([][]string, n)
+	// walk returns the positions after p, given the positions before it;
+	// position n stands for all the positions after the first n.
+	var walk func(p *pattern, at []bool) []bool
+	walkAll := func(children patternList, at []bool) []bool {
+		for _, child := range children {
+			at = walk(child, at)
+		}
+		return at
+	}
+	union := func(a, b []bool) bool {
+		changed := false
+		for i := range b {
+			if b[i] && !a[i] {
+				a[i], changed = true, true
+			}
+		}
+		return changed
+	}
+	walk = func(p *pattern, at []bool) []bool {
+		next := make([]bool, n+1)
+		switch {
+		case p.t&(patternArgument|patternCommand) != 0:
+			for i, ok := range at {
+				if !ok {
+					continue
+				}
+				if i == n {
+					next[n] = true
+					continue
+				}
+				if p.t&patternCommand != 0 && !slices.Contains(commands[i], p.name) {
+					commands[i] = append(commands[i], p.name)
+				}
+				next[i+1] = true
+			}
+		case p.t&patternOption != 0:
+			return at
+		case p.t&patternEither != 0:
+			for _, child := range p.children {
+				union(next, walk(child, at))
+			}
+		case p.t&(patternOptionAL|patternOptionSSHORTCUT) != 0:
+			copy(next, at)
+			union(next, walkAll(p.children, at))
+		case p.t&patternOneOrMore != 0:
+			next = walkAll(p.children, at)
+			for union(next, walkAll(p.children, next)) {
+			}
+		default:
+			next = walkAll(p.children, at)
+		}
+		return next
+	}
+	at := make([]bool, n+1)
+	at[0] = true
+	walk(p, at)
+	return commands
+}
+
+// suggest returns the name closest to s, if close enough: s is a prefix of
+// it, at least 3 bytes long, or differs by at most a third of its length (at
+// least 1) in the edit distance.
+func suggest(names []string, s string) string {
+	best, bestDist := "", 0
+	for _, name := range names {
+		dist := editDistance(name, s)
+		if len(s) >= 3 && strings.HasPrefix(name, s) {
+			dist = 0
+		}
+		if dist > max(1, len(name)/3) {
+			continue
+		}
+		if best == "" || dist < bestDist {
+			best, bestDist = name, dist
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b, in bytes.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,83 @@
+package docopt
+
+import (
+	"testing"
+
+	"github.com/go-quicktest/qt"
+)
+
+func TestCommandDescriptions(t *testing.T) {
+	doc := `Usage:
+  nf ship new <name>...
+  nf ship <name> move <x> <y>
+  nf mine (set|remove) <x> <y>
+  nf <command> [<args>...]
+
+Commands:
+  ship     Manage ships.
+  mine     Manage mines, a long description
+           on two lines.
+
+Other commands:
+  fly      Fly.`
+
+	commands, err := testParser.Commands(doc)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(commands, []Command{
+		{"ship", "Manage ships."},
+		{"new", ""},
+		{"move", ""},
+		{"mine", "Manage mines, a long description on two lines."},
+		{"set", ""},
+		{"remove", ""},
+		{"fly", "Fly."},
+	}))
+	qt.Assert(t, qt.Equals(testParser.SuggestCommand(doc, "fli"), "fly"))
+	qt.Assert(t, qt.Equals(testParser.SuggestCommand(doc, "rem"), "remove"))
+	qt.Assert(t, qt.Equals(testParser.SuggestCommand(doc, "swim"), ""))
+}
+
+func TestParseSuggestsCommands(t *testing.T) {
+	doc := `Usage:
+  nf ship new <name>...
+  nf ship <name> move <x> <y>
+  nf mine (set|remove) <x> <y>`
+	testCases := []struct {
+		argv []string
+		want string
+	}{
+		{[]string{"shp", "new", "x"}, "unknown command: shp, did you mean ship?"},
+		{[]string{"ship", "nw", "x"}, "unknown command: nw, did you mean new?"},
+		{[]string{"mine", "st", "1", "2"}, "unknown command: st, did you mean set?"},
+		{[]string{"fly"}, "unknown argument:  fly"},
+		// Only the arguments where a command is expected.
+		{[]string{"ship", "shi", "move", "1", "2", "extra"}, "unknown argument:  extra"},
+		{[]string{"ship", "shi", "move", "1"}, "unknown argument:  ship\nunknown argument:  shi\nunknown argument:  move\nunknown argument:  1"},
+		{[]string{"ship", "m", "move", "1"}, "unknown argument:  ship\nunknown argument:  m\nunknown argument:  move\nunknown argument:  1"},
+	}
+	for _, tc := range testCases {
+		_, err := testParser.Parse(doc, tc.argv, "")
+		qt.Assert(t, qt.ErrorAs(err, new(*UserError)))
+		qt.Assert(t, qt.Equals(err.Error(), tc.want), qt.Commentf("argv: %q", tc.argv))
+	}
+}
+
+func TestCommandsAt(t *testing.T) {
+	var o patternList
+	p, err := testParser.parsePattern("(ship [<name>] move | mine (set|remove)... <x>) [go]", &o)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(p.commandsAt(5), [][]string{
+		{"ship", "mine"},
+		{"move", "set", "remove"},
+		{"move", "set", "remove", "go"},
+		{"set", "remove", "go"},
+		{"set", "remove", "go"},
+	}))
+}
+
+func TestEditDistance(t *testing.T) {
+	qt.Assert(t, qt.Equals(editDistance("", ""), 0))
+	qt.Assert(t, qt.Equals(editDistance("ship", "shp"), 1))
+	qt.Assert(t, qt.Equals(editDistance("kitten", "sitting"), 3))
+	qt.Assert(t, qt.Equals(editDistance("abc", ""), 3))
+}
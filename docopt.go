@@ -7,20 +7,108 @@ package docopt
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"unicode"
 )
 
 type Parser struct {
+	// Mode is how the command-line is parsed; the zero value is ModeGNU.
+	Mode Mode
 	// OptionsFirst requires that option flags always come before positional
 	// arguments; otherwise they can overlap.
 	OptionsFirst bool
 	// SkipHelpFlags tells the parser not to look for -h and --help flags and
-	// call the HelpHandler.
+	// print the help.
 	SkipHelpFlags bool
+	// ShortPrefixes are the ASCII characters that start a short option, both
+	// in the doc and in the command-line. The default is "-". For example,
+	// "-+" allows also options like +v, different from -v.
+	ShortPrefixes string
+	// LongPrefixes are the strings that start a long option, both in the doc
+	// and in the command-line. The default is "--". For example,
+	// []string{"--", "/"} allows also Windows-style options like /quiet;
+	// then an argument like /tmp/file is taken as an option too.
+	// Whatever the prefixes, "--" separates options from arguments.
+	LongPrefixes []string
+	// UsageNames optionally names the usage lines, in order, to be reported
+	// in Result.UsageName.
+	UsageNames []string
+	// NoAbbrev requires long options to be given in full in the command-line;
+	// otherwise a unique prefix is accepted (--verb for --verbose).
+	// Scripts relying on abbreviations break when a new option with the same
+	// prefix is added.
+	NoAbbrev bool
+	// MinAbbrevLen is the minimum length, not counting the prefix ("--"), of
+	// an abbreviated long option in the command-line. Zero means any length.
+	MinAbbrevLen int
+	// ResponseFiles expands each argument @file in the command-line into the
+	// arguments contained in file, separated by white space, quoted with
+	// '...' or "..." and escaped with \ as in a shell. A response file can
+	// contain other @file arguments. The arguments after "--" are not
+	// expanded, so that "prog -- @name" passes "@name" as is.
+	// The indexes in Result.Events refer to the expanded command-line.
+	ResponseFiles bool
+	// Warn, if not nil, is called with warnings about the command-line: when
+	// a long option is abbreviated and when a deprecated option is used.
+	// If nil, only the latter are printed, to Stderr.
+	Warn func(msg string)
+	// FormatHelp prints the help with the descriptions of the options and
+	// arguments aligned in a column and wrapped to HelpWidth; the rest of the doc,
+	// including the usage, is kept as is. Otherwise the help is the doc as
+	// is, without the hidden options.
+	FormatHelp bool
+	// HelpWidth is the width of the help for FormatHelp. If 0, the value of
+	// the environment variable COLUMNS, or else 80.
+	HelpWidth int
+	// UsageOnError is what Parse prints after a usage error; the default is
+	// UsageFull.
+	UsageOnError UsageOnError
+	// Version, if not nil, provides the version printed by --version, in
+	// place of the version argument of Parse; see ReadVersion. If the doc
+	// declares the option --json and the user gives it with --version, the
+	// version is printed as JSON.
+	Version func() VersionInfo
+	// Messages translates the messages to the user, like the text of the
+	// usage errors and the warnings. If nil, English.
+	Messages Messages
+	// Color is when to highlight with ANSI colors the commands, arguments,
+	// options and defaults in the help and the usage errors; the default is
+	// ColorNever.
+	Color Color
+	// Stdout and Stderr are where Parse prints the help and the version, and
+	// the usage errors and the warnings. If nil, os.Stdout and os.Stderr.
+	Stdout io.Writer
+	Stderr io.Writer
+	// Exit is called by MustParse to exit with the given status. If nil,
+	// os.Exit. Tests can replace it to check the status.
+	Exit func(code int)
+	// UsageExitCode is the exit status of MustParse after a usage error. If
+	// 0, 2, as for the usage errors of the Unix tools.
+	UsageExitCode int
 }
 
+// Mode is how a Parser parses the command-line.
+type Mode int
+
+const (
+	// ModeGNU lets options and positional arguments come in any order, and
+	// long options be abbreviated. This is the default.
+	ModeGNU Mode = iota
+	// ModePOSIX stops looking for options at the first positional argument,
+	// as OptionsFirst does, requires long options to be given in full and
+	// rejects a short option argument given as -o=value.
+	ModePOSIX
+	// ModeEnv is ModePOSIX if the environment variable POSIXLY_CORRECT is
+	// set and not empty, otherwise ModeGNU, as for the GNU tools.
+	ModeEnv
+)
+
 // Parse parses args based on the interface described in doc.
 // It never calls os.Exit; you have to handle it yourself, allowing to properly unit
 // test command-line arguments. See the examples for idiomatic usage.
@@ -34,77 +122,190 @@ func Parse(doc string, args []string, version string) (Opts, error) {
 }
 
 // MustParse parses args based on the interface described in doc.
-// If the user asked for help, it prints it and then calls os.Exit(0).
-// If the user made an invocation error, it prints the error and calls os.Exit(1).
-// See [Parse] for an alternative that allows to unit test and control lifetime..
+// If the user asked for help or the version, it prints it and then calls os.Exit(0).
+// If the user made an invocation error, it prints the error and calls os.Exit(2).
+// See [Parse] for an alternative that allows to unit test and control lifetime,
+// and [Parser.MustParse] to configure the exit.
 func MustParse(doc string, argv []string, version string) Opts {
 	parser := &Parser{}
-	opts, err := parser.Parse(doc, argv, version)
-	if err != nil {
-		os.Exit(1)
+	return parser.MustParse(doc, argv, version)
+}
+
+// MustParse is like [Parser.Parse], but exits through p.Exit: with status 0
+// if the user asked for help or the version, p.UsageExitCode if the user made
+// an invocation error and 1 if the doc is invalid, after printing the error.
+// If p.Exit returns, as it can do in tests, MustParse returns nil.
+func (p *Parser) MustParse(doc string, argv []string, version string) Opts {
+	opts, err := p.Parse(doc, argv, version)
+	var userError *UserError
+	switch {
+	case err == nil:
+		return opts
+	case errors.Is(err, ErrHelp):
+		p.exit(0)
+	case errors.As(err, &userError):
+		p.exit(p.usageExitCode())
+	default:
+		fmt.Fprintln(p.stderr(), err)
+		p.exit(1)
 	}
-	return opts
+	return nil
+}
+
+// Result is the outcome of a successful parse.
+type Result struct {
+	// Opts maps options, arguments and commands to their values, as
+	// returned by Parse.
+	Opts Opts
+	// Usage is the index, starting from 0, of the usage line that matched
+	// the command-line.
+	//
+	// If more than one usage line matches, the one that consumes the most
+	// command-line arguments wins; if there is still a tie, the first one
+	// wins. The same rule applies to the alternatives (a | b) within a
+	// usage line.
+	Usage int
+	// UsageLine is the text of the usage line that matched, with white space
+	// normalized, for example "naval_fate ship new <name>...".
+	UsageLine string
+	// UsageName is the name given to the usage line that matched in
+	// Parser.UsageNames, if any.
+	UsageName string
+	// Events lists the options, arguments and commands in the order they
+	// appear in the command-line, for programs where the order matters
+	// (-i a -o x -i b -o y). Opts loses it.
+	Events []Event
+}
+
+// Event is the occurrence of an option, argument or command in the
+// command-line.
+type Event struct {
+	// Index is the index in argv of the element the event comes from.
+	// Grouped short options (-abc) share the same index, and so do an
+	// option and its argument given as a separate element (--file f).
+	Index int
+	// Key is the key in Opts.
+	Key string
+	// Value is true for flags (false for a negated one, --no-name) and
+	// commands, the arguments for an option with several ([]string), the
+	// implicit value for an option given without its optional argument, and
+	// otherwise the string given in the command-line.
+	Value any
 }
 
 // Parse parses custom arguments based on the interface described in doc.
 // If you provide a non-empty version string, then this will be displayed when
 // the --version flag is found.
+//
+// The flags -h and --help print the help and return ErrHelp. If the doc
+// declares the option --help-all, they print the doc only up to the end of
+// the last usage or options section, and --help-all prints all of it.
 func (p *Parser) Parse(doc string, argv []string, version string) (Opts, error) {
-	return p.parse(doc, argv, version)
+	res, err := p.ParseResult(doc, argv, version)
+	if res == nil {
+		return nil, err
+	}
+	return res.Opts, err
 }
 
-func (p *Parser) parse(doc string, args []string, version string) (map[string]any, error) {
-	opts, output, err := parse(doc, args, !p.SkipHelpFlags, version, p.OptionsFirst)
+// ParseResult is like [Parser.Parse], but returns also which usage line
+// matched the command-line. For example, to dispatch on it:
+//
+//	parser := &docopt.Parser{UsageNames: []string{"new", "move", "shoot"}}
+//	res, err := parser.ParseResult(usage, os.Args[1:], "")
+//	...
+//	switch res.UsageName {
+//	case "new":
+//	...
+func (p *Parser) ParseResult(doc string, argv []string, version string) (*Result, error) {
+	res, err := p.parse(doc, argv, version)
+	if res != nil && res.Usage < len(p.UsageNames) {
+		res.UsageName = p.UsageNames[res.Usage]
+	}
+	return res, err
+}
+
+func (p *Parser) parse(doc string, args []string, version string) (*Result, error) {
+	res, output, err := p.parseDoc(doc, args, version)
 	var userError *UserError
 	if errors.As(err, &userError) {
 		// the user gave us bad input
-		fmt.Fprintln(os.Stderr, output)
-		return opts, err
+		if p.UsageOnError != UsageFull {
+			output = p.errorOutput(doc, args, err)
+		}
+		if p.color(p.stderr()) {
+			output = p.colorizeError(output, err)
+		}
+		fmt.Fprintln(p.stderr(), output)
+		return res, err
 	}
 	// FIXME why are we looking at the len of output? Seems that this information
 	//   should instead be encoded only in the error...
 	if len(output) > 0 && err == nil {
 		// the user asked for help or --version
-		fmt.Println(output)
-		return opts, ErrHelp
+		fmt.Fprintln(p.stdout(), output)
+		return res, ErrHelp
 	}
-	return opts, err
+	return res, err
 }
 
 // -----------------------------------------------------------------------------
 
-// parse and return a map of args, output and all errors
-func parse(doc string, argv []string, help bool, version string, optionsFirst bool,
-) (map[string]any, string, error) {
+// parseDoc parses and returns the result, output and all errors.
+func (p *Parser) parseDoc(doc string, argv []string, version string) (*Result, string, error) {
 	if argv == nil {
-		return nil, "", &LanguageError{"command-line cannot be nil"}
+		return nil, "", &LanguageError{msg: "command-line cannot be nil"}
 	}
 
-	usageSections := parseSection("usage:", doc)
+	usageSections := findSections("usage:", doc)
 
 	if len(usageSections) == 0 {
-		return nil, "", &LanguageError{`"usage:" (case-insensitive) not found.`}
+		return nil, "", &LanguageError{msg: `"usage:" (case-insensitive) not found.`}
 	}
 	if len(usageSections) > 1 {
-		return nil, "", &LanguageError{`More than one "usage:" (case-insensitive).`}
+		err := &LanguageError{msg: `More than one "usage:" (case-insensitive).`}
+		err.locate(doc, usageSections[1].offset)
+		return nil, "", err
 	}
-	usage := usageSections[0]
+	usage := usageSections[0].text
 
-	options := parseDefaults(doc)
-	formal, err := formalUsage(usage)
+	options, err := p.parseDefaults(doc)
+	if err != nil {
+		return nil, "", err
+	}
+	// Parsing the pattern and argv can add to options; keep the ones from
+	// the doc for the [options] shortcut.
+	docOptions := slices.Clone(options)
+	tokens, err := tokenListFromUsage(doc, usageSections[0])
 	if err != nil {
 		return nil, handleError(err, usage), err
 	}
 
-	pat, err := parsePattern(formal, &options)
+	pat, err := p.parseTokens(tokens, &options)
 	if err != nil {
 		return nil, handleError(err, usage), err
 	}
 
-	patternArgv, err := parseArgv(newTokenList(argv, errorUser), &options, optionsFirst)
+	if p.ResponseFiles {
+		argv, err = p.expandResponseFiles(argv)
+		if err != nil {
+			return nil, handleError(err, usage), err
+		}
+	}
+	argvTokens := newTokenList(argv, errorUser)
+	argvTokens.offsets = make([]int, len(argv)+1)
+	for i := range argvTokens.offsets {
+		argvTokens.offsets[i] = i
+	}
+	patternArgv, err := p.parseArgv(argvTokens, &options)
 	if err != nil {
 		return nil, handleError(err, usage), err
 	}
+	// Matching changes the values of patternArgv, keep the original ones.
+	argvValues := make(map[*pattern]any, len(patternArgv))
+	for _, a := range patternArgv {
+		argvValues[a] = a.value
+	}
 	patFlat, err := pat.flat(patternOption)
 	if err != nil {
 		return nil, handleError(err, usage), err
@@ -115,12 +316,54 @@ func parse(doc string, argv []string, help bool, version string, optionsFirst bo
 	if err != nil {
 		return nil, handleError(err, usage), err
 	}
-	for _, optionsShortcut := range patFlat {
-		docOptions := parseDefaults(doc)
-		optionsShortcut.children = docOptions.unique().diff(patternOptions)
+	if len(patFlat) > 0 {
+		shortcutOptions := docOptions.unique().diff(patternOptions)
+		for _, optionsShortcut := range patFlat {
+			optionsShortcut.children = shortcutOptions
+		}
+	}
+
+	// declared reports whether the doc declares the option name, in the
+	// usage or in the options sections.
+	declared := func(name string) bool {
+		isName := func(o *pattern) bool { return o.name == name }
+		return slices.ContainsFunc(patternOptions, isName) || slices.ContainsFunc(docOptions, isName)
+	}
+	// helpDoc returns the help, of topic if it names a section.
+	helpDoc := func(all bool, topic string) (string, bool) {
+		text, isTopic := doc, false
+		if topic != "" {
+			if sect, ok := helpTopic(doc, topic); ok {
+				text, isTopic = sect, true
+			}
+		}
+		if !isTopic && !all && declared("--help-all") {
+			text = shortHelp(doc)
+		}
+		help := p.help(text, docOptions)
+		if p.color(p.stdout()) {
+			help = p.colorize(help)
+		}
+		return help, isTopic
 	}
 
-	if output := extras(help, version, patternArgv, doc); len(output) > 0 {
+	var versionDoc func(json bool) string
+	if p.Version != nil {
+		versionDoc = func(json bool) string {
+			v := p.Version()
+			if v.Program == "" {
+				v.Program, _, _ = stringPartition(usageLines(usage)[0], " ")
+			}
+			if json {
+				return v.JSON()
+			}
+			return v.String()
+		}
+	} else if version != "" {
+		versionDoc = func(bool) string { return version }
+	}
+	output := extras(!p.SkipHelpFlags, pat.hasLeaf(patternCommand, "help"), declared, versionDoc, patternArgv, helpDoc)
+	if len(output) > 0 {
 		return nil, output, nil
 	}
 
@@ -128,13 +371,30 @@ func parse(doc string, argv []string, help bool, version string, optionsFirst bo
 	if err != nil {
 		return nil, handleError(err, usage), err
 	}
-	matched, left, collected := pat.match(&patternArgv, nil)
+	line, matched, left, collected, tr := pat.matchUsage(&patternArgv)
 	if matched && len(*left) == 0 {
 		patFlat, err = pat.flat(patternDefault)
 		if err != nil {
 			return nil, handleError(err, usage), err
 		}
-		return append(patFlat, *collected...).dictionary(), "", nil
+		opts := Opts(append(patFlat, *collected...).dictionary())
+		setArgumentDefaults(opts, doc, pat.usageLine(line))
+		if err := p.setMaps(opts, docOptions); err != nil {
+			return nil, handleError(err, usage), err
+		}
+		warned := make(map[string]bool)
+		for _, a := range patternArgv {
+			if a.deprecated != "" && !warned[a.name] {
+				p.notify(a.deprecated)
+				warned[a.name] = true
+			}
+		}
+		return &Result{
+			Opts:      opts,
+			Usage:     line,
+			UsageLine: usageLines(usage)[line],
+			Events:    events(tr, patternArgv, argvValues),
+		}, "", nil
 	}
 
 	// left contains all the non-matched elements, that is, the errors.
@@ -142,18 +402,69 @@ func parse(doc string, argv []string, help bool, version string, optionsFirst bo
 	bho := make([]string, 0, len(*left))
 	for _, unknown := range *left {
 		if unknown.t == patternOption {
-			bho = append(bho, fmt.Sprintf("unknown %s: %s",
-				unknown.t, unknown.name))
+			bho = append(bho, p.message(MsgUnknownOption, unknown.name))
 		} else {
 			// FIXME too optimistic ...
-			bho = append(bho, fmt.Sprintf("unknown %s: %s %v",
-				unknown.t, unknown.name, unknown.value))
+			bho = append(bho, p.message(MsgUnknownArgument, unknown.name, unknown.value))
 		}
 	}
 	err = &UserError{strings.Join(bho, "\n")}
+	if cmdErr := p.errUnknownCommand(pat, patternArgv, *left); cmdErr != nil {
+		err = cmdErr
+	}
 	return nil, handleError(err, usage), err
 }
 
+// setMaps sets in opts the values of the map options to a map[string]string
+// of their arguments key=value.
+func (p *Parser) setMaps(opts Opts, options patternList) error {
+	for _, o := range options {
+		v, ok := opts[o.name]
+		if !o.isMap || !ok {
+			continue
+		}
+		var pairs []string
+		switch v := v.(type) {
+		case string:
+			pairs = []string{v}
+		case []string:
+			pairs = v
+		}
+		m := make(map[string]string, len(pairs))
+		for _, pair := range pairs {
+			key, value, ok := strings.Cut(pair, "=")
+			if !ok {
+				return &UserError{p.message(MsgRequiresKeyValue, o.name, pair)}
+			}
+			m[key] = value
+		}
+		opts[o.name] = m
+	}
+	return nil
+}
+
+// events returns the events of the matched leaves of tr, in the order of
+// patternArgv.
+func events(tr *trail, patternArgv patternList, argvValues map[*pattern]any) []Event {
+	leaves := make(map[*pattern]*pattern, len(patternArgv))
+	for ; tr != nil; tr = tr.prev {
+		leaves[tr.arg] = tr.leaf
+	}
+	evs := make([]Event, 0, len(patternArgv))
+	for _, a := range patternArgv {
+		leaf, ok := leaves[a]
+		if !ok {
+			continue
+		}
+		value := argvValues[a]
+		if leaf.t == patternCommand {
+			value = true
+		}
+		evs = append(evs, Event{Index: a.argvIndex, Key: leaf.name, Value: value})
+	}
+	return evs
+}
+
 func handleError(err error, usage string) string {
 	if _, ok := err.(*UserError); ok {
 		return strings.TrimSpace(fmt.Sprintf("%s\n%s", err, usage))
@@ -161,49 +472,211 @@ func handleError(err error, usage string) string {
 	return ""
 }
 
+// parseSection returns the sections of source whose heading contains name,
+// or all the named sections if name is empty, in order.
 func parseSection(name, source string) []string {
-	p := regexp.MustCompile(`(?im)^([^\n]*` + name + `[^\n]*\n?(?:[ \t].*?(?:\n|$))*)`)
-	s := p.FindAllString(source, -1)
-	if s == nil {
-		s = []string{}
-	}
-	for i, v := range s {
-		s[i] = strings.TrimSpace(v)
+	s := []string{}
+	for _, sect := range findSections(name, source) {
+		s = append(s, sect.text)
 	}
 	return s
 }
 
-func parseDefaults(doc string) patternList {
+// section is a section of the doc, as found by findSections.
+type section struct {
+	text   string // trimmed of leading and trailing white space
+	offset int    // of text in the doc
+}
+
+// optionDescription is the description of an option in an options section.
+type optionDescription struct {
+	section
+	// group is the heading of the options section, without the colon, like
+	// "Options" or "Network options".
+	group string
+}
+
+var reOptionsHeading = regexp.MustCompile(`(?i)options:`)
+
+// optionsGroup returns the group of an options section, given its heading:
+// the text before the first colon, like "Network options" for "Network
+// options:" and "bla" for "bla: options:", or "Options" if empty.
+func optionsGroup(heading string) string {
+	group, _, _ := stringPartition(heading, ":")
+	if group = strings.TrimSpace(group); group == "" {
+		return "Options"
+	}
+	return group
+}
+
+// optionsHeading returns the end of the heading of an options section: the
+// text up to "options:", so that in "bla: options: --foo" the options start
+// with --foo.
+func optionsHeading(text string) int {
+	return reOptionsHeading.FindStringIndex(text)[1]
+}
+
+var (
+	reSections = map[string]*regexp.Regexp{
+		"usage:":     sectionRegexp("usage:"),
+		"options:":   sectionRegexp("options:"),
+		"arguments:": sectionRegexp("arguments:"),
+		// Any section with a heading "Name:" at the start of a line.
+		"": regexp.MustCompile(`(?m)^([A-Za-z][\w \t-]*:[^\n]*\n?(?:[ \t].*?(?:\n|$))*)`),
+	}
+	reOptionDescription = regexp.MustCompile(`\n[ \t]*(-\S+?)`)
+	reDefault           = regexp.MustCompile(`(?i)\[default: (.*)\]`)
+	reImplicit          = regexp.MustCompile(`(?i)\[implicit: (.*?)\]`)
+	reOptionalArgument  = regexp.MustCompile(`\[=([^\]\s]*)\]`)
+	reMap               = regexp.MustCompile(`(?i)\[map\]`)
+	reHidden            = regexp.MustCompile(`(?i)\[hidden\]`)
+	reDeprecated        = regexp.MustCompile(`(?i)\[deprecated(?::\s*(.*?))?\]`)
+)
+
+func sectionRegexp(name string) *regexp.Regexp {
+	return regexp.MustCompile(`(?im)^([^\n]*` + name + `[^\n]*\n?(?:[ \t].*?(?:\n|$))*)`)
+}
+
+func findSections(name, source string) []section {
+	p, ok := reSections[name]
+	if !ok {
+		p = sectionRegexp(name)
+	}
+	var sections []section
+	for _, loc := range p.FindAllStringIndex(source, -1) {
+		raw := source[loc[0]:loc[1]]
+		text := strings.TrimSpace(raw)
+		offset := loc[0] + len(raw) - len(strings.TrimLeftFunc(raw, unicode.IsSpace))
+		sections = append(sections, section{text, offset})
+	}
+	return sections
+}
+
+// parseDefaults returns the options of the options sections of doc. The
+// error, a LanguageError located in doc, is for an invalid option; the
+// options are returned anyway.
+func (p *Parser) parseDefaults(doc string) (patternList, error) {
 	defaults := patternList{}
-	p := regexp.MustCompile(`\n[ \t]*(-\S+?)`)
-	for _, s := range parseSection("options:", doc) {
-		// FIXME corner case "bla: options: --foo"
-		_, _, s = stringPartition(s, ":") // get rid of "options:"
-		split := p.Split("\n"+s, -1)[1:]
-		match := p.FindAllStringSubmatch("\n"+s, -1)
-		for i := range split {
-			optionDescription := match[i][1] + split[i]
-			if strings.HasPrefix(optionDescription, "-") {
-				defaults = append(defaults, parseOption(optionDescription))
+	var firstErr error
+	for _, desc := range p.optionDescriptions(doc) {
+		o, err := p.parseOption(desc.text)
+		var langErr *LanguageError
+		if errors.As(err, &langErr) && firstErr == nil {
+			langErr.locate(doc, desc.offset)
+			firstErr = err
+		}
+		defaults = append(defaults, o)
+	}
+	return defaults, firstErr
+}
+
+// optionDescriptions returns the option descriptions in the options sections
+// of doc, located in doc.
+func (p *Parser) optionDescriptions(doc string) []optionDescription {
+	var descs []optionDescription
+	re := p.optionDescriptionRegexp()
+	for _, sect := range findSections("options:", doc) {
+		start := optionsHeading(sect.text) // get rid of "options:"
+		group := optionsGroup(sect.text[:start])
+		s := "\n" + sect.text[start:]
+		locs := re.FindAllStringSubmatchIndex(s, -1)
+		for i, loc := range locs {
+			end := len(s)
+			if i+1 < len(locs) {
+				end = locs[i+1][0]
+			}
+			desc := optionDescription{section{s[loc[2]:end], sect.offset + start + loc[2] - 1}, group}
+			if p.isOption(desc.text) {
+				descs = append(descs, desc)
 			}
 		}
 	}
-	return defaults
+	return descs
 }
 
-func parsePattern(source string, options *patternList) (*pattern, error) {
-	tokens := tokenListFromPattern(source)
-	result, err := parseExpr(tokens, options)
+// optionDescriptionRegexp returns the regexp that finds the option
+// descriptions in an options section, as reOptionDescription does for the
+// default prefixes.
+func (p *Parser) optionDescriptionRegexp() *regexp.Regexp {
+	if p.ShortPrefixes == "" && p.LongPrefixes == nil {
+		return reOptionDescription
+	}
+	var chars strings.Builder
+	for _, c := range []byte(p.shortPrefixes()) {
+		fmt.Fprintf(&chars, `\x{%x}`, c)
+	}
+	for _, prefix := range p.longPrefixes() {
+		fmt.Fprintf(&chars, `\x{%x}`, prefix[0])
+	}
+	if re, ok := reOptionDescriptions.Load(chars.String()); ok {
+		return re.(*regexp.Regexp)
+	}
+	re, _ := reOptionDescriptions.LoadOrStore(chars.String(), regexp.MustCompile(`\n[ \t]*([`+chars.String()+`]\S+?)`))
+	return re.(*regexp.Regexp)
+}
+
+// reOptionDescriptions caches the regexps of optionDescriptionRegexp for
+// custom prefixes, by the characters starting an option.
+var reOptionDescriptions sync.Map
+
+func (p *Parser) shortPrefixes() string {
+	if p.ShortPrefixes == "" {
+		return "-"
+	}
+	return p.ShortPrefixes
+}
+
+func (p *Parser) longPrefixes() []string {
+	if p.LongPrefixes == nil {
+		return []string{"--"}
+	}
+	return p.LongPrefixes
+}
+
+// longPrefix returns the prefix of s if s is a long option, otherwise "".
+func (p *Parser) longPrefix(s string) string {
+	for _, prefix := range p.longPrefixes() {
+		if len(s) > len(prefix) && strings.HasPrefix(s, prefix) {
+			return prefix
+		}
+	}
+	return ""
+}
+
+// shortPrefix returns the prefix of s if s is one or more short options,
+// otherwise "".
+func (p *Parser) shortPrefix(s string) string {
+	if len(s) < 2 || p.longPrefix(s) != "" || strings.IndexByte(p.shortPrefixes(), s[0]) < 0 {
+		return ""
+	}
+	return s[:1]
+}
+
+// missingLongPrefix reports whether s, in the doc, looks like a long option
+// (--name) but "--" is not one of the long prefixes, so that it would be
+// taken as the short options - and n, a, m, e.
+func (p *Parser) missingLongPrefix(s string) bool {
+	return len(s) > 2 && strings.HasPrefix(s, "--") && p.longPrefix(s) == "" && p.shortPrefix(s) != ""
+}
+
+// isOption reports whether s starts with a long or short option.
+func (p *Parser) isOption(s string) bool {
+	return p.longPrefix(s) != "" || p.shortPrefix(s) != ""
+}
+
+func (p *Parser) parseTokens(tokens *tokenList, options *patternList) (*pattern, error) {
+	result, err := p.parseExpr(tokens, options)
 	if err != nil {
 		return nil, err
 	}
 	if tokens.current() != nil {
-		return nil, tokens.errorFunc("unexpected ending: %s" + strings.Join(tokens.tokens, " "))
+		return nil, tokens.errorAt(tokens.offset(), "unexpected ending: %s",
+			strings.Join(tokens.tokens, " "))
 	}
 	return newRequired(result...), nil
 }
 
-func parseArgv(tokens *tokenList, options *patternList, optionsFirst bool) (patternList, error) {
+func (p *Parser) parseArgv(tokens *tokenList, options *patternList) (patternList, error) {
 	/*
 		Parse command-line argument vector.
 
@@ -212,42 +685,76 @@ func parseArgv(tokens *tokenList, options *patternList, optionsFirst bool) (patt
 		else:
 			argv ::= [ long | shorts | argument ]* [ '--' [ argument ]* ] ;
 	*/
+	posix := p.posix()
 	parsed := patternList{}
+	// add appends to parsed, recording the index in argv if known.
+	add := func(index int, pl ...*pattern) {
+		for _, arg := range pl {
+			if index >= 0 {
+				arg.argvIndex = index
+			}
+			parsed = append(parsed, arg)
+		}
+	}
+	// rest adds the remaining tokens as arguments.
+	rest := func() {
+		for tokens.current() != nil {
+			add(tokens.offset(), newArgument("", tokens.move().String()))
+		}
+	}
 	for tokens.current() != nil {
+		index := tokens.offset()
 		if tokens.current().eq("--") {
-			for _, v := range tokens.tokens {
-				parsed = append(parsed, newArgument("", v))
-			}
+			rest()
 			return parsed, nil
-		} else if tokens.current().hasPrefix("--") {
-			pl, err := parseLong(tokens, options)
+		} else if p.longPrefix(tokens.current().String()) != "" {
+			pl, err := p.parseLong(tokens, options)
 			if err != nil {
 				return nil, err
 			}
-			parsed = append(parsed, pl...)
-		} else if tokens.current().hasPrefix("-") && !tokens.current().eq("-") {
-			ps, err := parseShorts(tokens, options)
+			add(index, pl...)
+		} else if p.shortPrefix(tokens.current().String()) != "" {
+			ps, err := p.parseShorts(tokens, options)
 			if err != nil {
 				return nil, err
 			}
-			parsed = append(parsed, ps...)
-		} else if optionsFirst {
-			for _, v := range tokens.tokens {
-				parsed = append(parsed, newArgument("", v))
-			}
+			add(index, ps...)
+		} else if p.OptionsFirst || posix {
+			rest()
 			return parsed, nil
 		} else {
-			parsed = append(parsed, newArgument("", tokens.move().String()))
+			add(index, newArgument("", tokens.move().String()))
 		}
 	}
 	return parsed, nil
 }
 
-func parseOption(optionDescription string) *pattern {
+// parseOption returns the option of an option description. The error, a
+// LanguageError, is for an invalid default or an option with a prefix that
+// is not configured; the option is returned anyway.
+func (p *Parser) parseOption(optionDescription string) (*pattern, error) {
 	optionDescription = strings.TrimSpace(optionDescription)
 	options, _, description := stringPartition(optionDescription, "  ")
+	// --name[=<arg>]: the argument is optional.
+	optional := reOptionalArgument.MatchString(options)
+	if optional {
+		options = reOptionalArgument.ReplaceAllString(options, " $1")
+	}
+	implicit := ""
+	if matched := reImplicit.FindStringSubmatch(description); matched != nil {
+		implicit = matched[1]
+		description = reImplicit.ReplaceAllString(description, "")
+	}
 	options = strings.ReplaceAll(options, ",", " ")
-	options = strings.ReplaceAll(options, "=", " ")
+	var fields []string
+	for _, s := range strings.Fields(options) {
+		// Split --name=ARG, but not an argument like <key>=<value>.
+		if name, arg, ok := strings.Cut(s, "="); ok && p.isOption(name) {
+			fields = append(fields, name, arg)
+		} else {
+			fields = append(fields, s)
+		}
+	}
 
 	short := ""
 	long := ""
@@ -255,30 +762,75 @@ func parseOption(optionDescription string) *pattern {
 	var value any
 	value = false
 
-	reDefault := regexp.MustCompile(`(?i)\[default: (.*)\]`)
-	for _, s := range strings.Fields(options) {
-		if strings.HasPrefix(s, "--") {
+	negated := ""
+	var err error
+	// The arguments after the last option name, as in -o FILE, --output FILE.
+	run := 0
+	for _, s := range fields {
+		if p.missingLongPrefix(s) && err == nil {
+			err = &LanguageError{msg: fmt.Sprintf("%s: -- is not a long prefix", s)}
+		}
+		if prefix := p.longPrefix(s); prefix != "" && strings.HasPrefix(s, prefix+"[no-]") {
+			name := strings.TrimPrefix(s, prefix+"[no-]")
+			long, negated = prefix+name, prefix+"no-"+name
+			run = 0
+		} else if p.longPrefix(s) != "" {
 			long = s
-		} else if strings.HasPrefix(s, "-") {
+			run = 0
+		} else if p.shortPrefix(s) != "" {
 			short = s
+			run = 0
 		} else {
-			argcount = 1
+			run++
+			argcount = max(argcount, run)
 		}
-		if argcount > 0 {
-			matched := reDefault.FindAllStringSubmatch(description, -1)
-			if len(matched) > 0 {
-				value = matched[0][1]
-			} else {
-				value = nil
+	}
+	if argcount > 0 {
+		matched := reDefault.FindAllStringSubmatch(description, -1)
+		if len(matched) > 0 {
+			value = matched[0][1]
+			if argcount > 1 {
+				value = strings.Fields(matched[0][1])
+			}
+		} else {
+			value = nil
+		}
+	}
+	opt := newOption(short, long, argcount, value)
+	if optional && argcount > 0 {
+		opt.optional = true
+		opt.implicit = implicit
+	}
+	opt.hidden = reHidden.MatchString(description)
+	if matched := reDeprecated.FindStringSubmatch(description); matched != nil {
+		opt.deprecated = p.message(MsgDeprecated, opt.name)
+		if matched[1] != "" {
+			opt.deprecated = p.message(MsgDeprecatedNote, opt.name, matched[1])
+		}
+	}
+	if reMap.MatchString(description) && argcount == 1 {
+		opt.isMap = true
+		if value != nil {
+			opt.value = strings.Fields(value.(string))
+		}
+	}
+	if negated != "" && argcount == 0 {
+		opt.negated = negated
+		matched := reDefault.FindStringSubmatch(description)
+		if matched != nil {
+			b, parseErr := strconv.ParseBool(matched[1])
+			if parseErr != nil {
+				return opt, &LanguageError{msg: fmt.Sprintf("%s: default must be true or false, got: %s", long, matched[1])}
 			}
+			opt.value = b
 		}
 	}
-	return newOption(short, long, argcount, value)
+	return opt, err
 }
 
-func parseExpr(tokens *tokenList, options *patternList) (patternList, error) {
+func (p *Parser) parseExpr(tokens *tokenList, options *patternList) (patternList, error) {
 	// expr ::= seq ( '|' seq )* ;
-	seq, err := parseSeq(tokens, options)
+	seq, err := p.parseSeq(tokens, options)
 	if err != nil {
 		return nil, err
 	}
@@ -293,7 +845,7 @@ func parseExpr(tokens *tokenList, options *patternList) (patternList, error) {
 	}
 	for tokens.current().eq("|") {
 		tokens.move()
-		seq, err = parseSeq(tokens, options)
+		seq, err = p.parseSeq(tokens, options)
 		if err != nil {
 			return nil, err
 		}
@@ -309,11 +861,11 @@ func parseExpr(tokens *tokenList, options *patternList) (patternList, error) {
 	return result, nil
 }
 
-func parseSeq(tokens *tokenList, options *patternList) (patternList, error) {
+func (p *Parser) parseSeq(tokens *tokenList, options *patternList) (patternList, error) {
 	// seq ::= ( atom [ '...' ] )* ;
 	result := patternList{}
 	for !tokens.current().match(true, "]", ")", "|") {
-		atom, err := parseAtom(tokens, options)
+		atom, err := p.parseAtom(tokens, options)
 		if err != nil {
 			return nil, err
 		}
@@ -326,14 +878,15 @@ func parseSeq(tokens *tokenList, options *patternList) (patternList, error) {
 	return result, nil
 }
 
-func parseAtom(tokens *tokenList, options *patternList) (patternList, error) {
+func (p *Parser) parseAtom(tokens *tokenList, options *patternList) (patternList, error) {
 	// atom ::= '(' expr ')' | '[' expr ']' | 'options' | long | shorts | argument | command ;
 	tok := tokens.current()
+	offset := tokens.offset()
 	result := patternList{}
 	if tokens.current().match(false, "(", "[") {
 		tokens.move()
 		var matching string
-		pl, err := parseExpr(tokens, options)
+		pl, err := p.parseExpr(tokens, options)
 		if err != nil {
 			return nil, err
 		}
@@ -346,25 +899,35 @@ func parseAtom(tokens *tokenList, options *patternList) (patternList, error) {
 		}
 		moved := tokens.move()
 		if !moved.eq(matching) {
-			return nil, tokens.errorFunc("unmatched '%s', expected: '%s' got: '%s'", tok, matching, moved)
+			return nil, tokens.errorAt(offset, "unmatched '%s', expected: '%s' got: '%s'", tok, matching, moved)
 		}
 		return result, nil
 	} else if tok.eq("options") {
 		tokens.move()
 		return patternList{newOptionsShortcut()}, nil
-	} else if tok.hasPrefix("--") && !tok.eq("--") {
-		return parseLong(tokens, options)
-	} else if tok.hasPrefix("-") && !tok.eq("-") && !tok.eq("--") {
-		return parseShorts(tokens, options)
+	} else if p.longPrefix(tok.String()) != "" {
+		return p.parseLong(tokens, options)
+	} else if p.missingLongPrefix(tok.String()) {
+		return nil, tokens.errorAt(offset, "%s: -- is not a long prefix", tok)
+	} else if p.shortPrefix(tok.String()) != "" && !tok.eq("--") {
+		return p.parseShorts(tokens, options)
 	} else if tok.hasPrefix("<") && tok.hasSuffix(">") || tok.isUpper() {
 		return patternList{newArgument(tokens.move().String(), nil)}, nil
 	}
 	return patternList{newCommand(tokens.move().String(), false)}, nil
 }
 
-func parseLong(tokens *tokenList, options *patternList) (patternList, error) {
-	// long ::= '--' chars [ ( ' ' | '=' ) chars ] ;
-	long, eq, v := stringPartition(tokens.move().String(), "=")
+func (p *Parser) parseLong(tokens *tokenList, options *patternList) (patternList, error) {
+	// long ::= '--' chars [ ( ' ' | '=' ) chars ] | '--' chars '[=' chars ']' ;
+	tok := tokens.move().String()
+	// In the doc, --name[=<arg>] declares an optional argument.
+	optional := false
+	if before, arg, ok := strings.Cut(tok, "[="); ok && tokens.err == errorLanguage &&
+		strings.HasSuffix(arg, "]") {
+		tok = before + "=" + strings.TrimSuffix(arg, "]")
+		optional = true
+	}
+	long, eq, v := stringPartition(tok, "=")
 	var value any
 	var opt *pattern
 	if eq == "" && v == "" {
@@ -373,35 +936,50 @@ func parseLong(tokens *tokenList, options *patternList) (patternList, error) {
 		value = v
 	}
 
-	if !strings.HasPrefix(long, "--") {
-		return nil, fmt.Errorf("long option '%s' doesn't start with --", long)
+	prefix := p.longPrefix(long)
+	if prefix == "" {
+		return nil, fmt.Errorf("long option '%s' doesn't start with %s", long,
+			strings.Join(p.longPrefixes(), " or "))
 	}
 	similar := patternList{}
+	// The names of similar, --no-name if negated.
+	similarLong := []string{}
 	for _, o := range *options {
 		if o.long == long {
 			similar = append(similar, o)
+			similarLong = append(similarLong, o.long)
 		}
 	}
-	if tokens.err == errorUser && len(similar) == 0 { // if no exact match
-		similar = patternList{}
+	if len(similar) == 0 {
+		for _, o := range *options {
+			if o.negated != "" && o.negated == long {
+				similar = append(similar, o)
+				similarLong = append(similarLong, long)
+			}
+		}
+	}
+	abbrev := tokens.err == errorUser && len(similar) == 0 && !p.NoAbbrev &&
+		len(long)-len(prefix) >= p.MinAbbrevLen && !p.posix()
+	if abbrev { // if no exact match
 		for _, o := range *options {
 			if strings.HasPrefix(o.long, long) {
 				similar = append(similar, o)
+				similarLong = append(similarLong, o.long)
+			} else if o.negated != "" && strings.HasPrefix(o.negated, long) {
+				similar = append(similar, o)
+				similarLong = append(similarLong, o.negated)
 			}
 		}
 	}
 	if len(similar) > 1 { // might be simply specified ambiguously 2+ times?
-		similarLong := make([]string, len(similar))
-		for i, s := range similar {
-			similarLong[i] = s.long
-		}
-		return nil, tokens.errorFunc("%s is not a unique prefix: %s?", long, strings.Join(similarLong, ", "))
+		return nil, tokens.errorFunc("%s", p.message(MsgNotUniquePrefix, long, strings.Join(similarLong, ", ")))
 	} else if len(similar) < 1 {
 		argcount := 0
 		if eq == "=" {
 			argcount = 1
 		}
 		opt = newOption("", long, argcount, false)
+		opt.optional = optional
 		*options = append(*options, opt)
 		if tokens.err == errorUser {
 			var val any
@@ -413,27 +991,37 @@ func parseLong(tokens *tokenList, options *patternList) (patternList, error) {
 			opt = newOption("", long, argcount, val)
 		}
 	} else {
-		opt = newOption(similar[0].short, similar[0].long, similar[0].argcount, similar[0].value)
+		opt = similar[0].copyLeaf()
+		negated := similarLong[0] != opt.long
+		if abbrev {
+			p.warn(MsgAbbreviation, long, similarLong[0])
+		}
 		if opt.argcount == 0 {
 			if value != nil {
-				return nil, tokens.errorFunc("%s must not have an argument", opt.long)
+				return nil, tokens.errorFunc("%s", p.message(MsgMustNotHaveArgument, similarLong[0]))
 			}
-		} else {
+		} else if opt.optional {
 			if value == nil {
+				value = opt.implicit
+			}
+		} else {
+			var values []string
+			if value != nil {
+				values = append(values, value.(string))
+			}
+			for len(values) < opt.argcount {
 				if tokens.current().match(true, "--") {
-					return nil, tokens.errorFunc("%s requires argument", opt.long)
-				}
-				moved := tokens.move()
-				if moved != nil {
-					value = moved.String() // only set as string if not nil
+					return nil, p.errRequiresArgument(tokens, opt.long, opt.argcount)
 				}
+				values = append(values, tokens.move().String())
 			}
+			value = optionValue(values)
 		}
 		if tokens.err == errorUser {
 			if value != nil {
 				opt.value = value
 			} else {
-				opt.value = true
+				opt.value = !negated
 			}
 		}
 	}
@@ -441,17 +1029,91 @@ func parseLong(tokens *tokenList, options *patternList) (patternList, error) {
 	return patternList{opt}, nil
 }
 
-func parseShorts(tokens *tokenList, options *patternList) (patternList, error) {
+// optionValue returns the value of an option given the values of its
+// arguments: a string for one argument, otherwise a []string.
+func optionValue(values []string) any {
+	if len(values) == 1 {
+		return values[0]
+	}
+	return values
+}
+
+// errRequiresArgument returns the error for the option name given without
+// all of its argcount arguments.
+func (p *Parser) errRequiresArgument(tokens *tokenList, name string, argcount int) error {
+	if argcount == 1 {
+		return tokens.errorFunc("%s", p.message(MsgRequiresArgument, name))
+	}
+	return tokens.errorFunc("%s", p.message(MsgRequiresArguments, name, argcount))
+}
+
+// posix reports whether the command-line is parsed in POSIX mode, see Mode.
+func (p *Parser) posix() bool {
+	switch p.Mode {
+	case ModePOSIX:
+		return true
+	case ModeEnv:
+		return os.Getenv("POSIXLY_CORRECT") != ""
+	}
+	return false
+}
+
+// warn reports the warning m to p.Warn, if set.
+func (p *Parser) warn(m Message, a ...any) {
+	if p.Warn != nil {
+		p.Warn(p.message(m, a...))
+	}
+}
+
+// notify reports a warning to p.Warn if set, otherwise to p.Stderr.
+func (p *Parser) notify(msg string) {
+	if p.Warn != nil {
+		p.Warn(msg)
+		return
+	}
+	fmt.Fprintln(p.stderr(), p.message(MsgWarning, msg))
+}
+
+func (p *Parser) stdout() io.Writer {
+	if p.Stdout == nil {
+		return os.Stdout
+	}
+	return p.Stdout
+}
+
+func (p *Parser) stderr() io.Writer {
+	if p.Stderr == nil {
+		return os.Stderr
+	}
+	return p.Stderr
+}
+
+func (p *Parser) exit(code int) {
+	if p.Exit == nil {
+		os.Exit(code)
+	}
+	p.Exit(code)
+}
+
+func (p *Parser) usageExitCode() int {
+	if p.UsageExitCode == 0 {
+		return 2
+	}
+	return p.UsageExitCode
+}
+
+func (p *Parser) parseShorts(tokens *tokenList, options *patternList) (patternList, error) {
 	// shorts ::= '-' ( chars )* [ [ ' ' ] chars ] ;
 	tok := tokens.move()
-	if !tok.hasPrefix("-") || tok.hasPrefix("--") {
-		return nil, fmt.Errorf("short option '%s' doesn't start with -", tok)
+	prefix := p.shortPrefix(tok.String())
+	if prefix == "" {
+		return nil, fmt.Errorf("short option '%s' doesn't start with one of %s", tok, p.shortPrefixes())
 	}
-	left := strings.TrimLeft(tok.String(), "-")
+	left := tok.String()[len(prefix):]
 	parsed := patternList{}
 	for left != "" {
 		var opt *pattern
-		short := "-" + left[0:1]
+		short := prefix + left[0:1]
 		left = left[1:]
 		similar := patternList{}
 		for _, o := range *options {
@@ -460,7 +1122,7 @@ func parseShorts(tokens *tokenList, options *patternList) (patternList, error) {
 			}
 		}
 		if len(similar) > 1 {
-			return nil, tokens.errorFunc("%s is specified ambiguously %d times", short, len(similar))
+			return nil, tokens.errorFunc("%s", p.message(MsgSpecifiedAmbiguously, short, len(similar)))
 		} else if len(similar) < 1 {
 			opt = newOption(short, "", 0, false)
 			*options = append(*options, opt)
@@ -468,18 +1130,31 @@ func parseShorts(tokens *tokenList, options *patternList) (patternList, error) {
 				opt = newOption(short, "", 0, true)
 			}
 		} else { // why copying is necessary here?
-			opt = newOption(short, similar[0].long, similar[0].argcount, similar[0].value)
+			opt = similar[0].copyLeaf()
 			var value any
-			if opt.argcount > 0 {
-				if left == "" {
-					if tokens.current().match(true, "--") {
-						return nil, tokens.errorFunc("%s requires argument", short)
-					}
-					value = tokens.move().String()
-				} else {
+			if opt.optional {
+				// Only -nARG gives the argument.
+				value = opt.implicit
+				if left != "" {
 					value = left
 					left = ""
 				}
+			} else if opt.argcount > 0 {
+				var values []string
+				if left != "" {
+					if tokens.err == errorUser && left[0] == '=' && p.posix() {
+						return nil, tokens.errorFunc("%s", p.message(MsgMustNotBeFollowedByEqual, short, tok))
+					}
+					values = append(values, left)
+					left = ""
+				}
+				for len(values) < opt.argcount {
+					if tokens.current().match(true, "--") {
+						return nil, p.errRequiresArgument(tokens, short, opt.argcount)
+					}
+					values = append(values, tokens.move().String())
+				}
+				value = optionValue(values)
 			}
 			if tokens.err == errorUser {
 				if value != nil {
@@ -494,45 +1169,154 @@ func parseShorts(tokens *tokenList, options *patternList) (patternList, error) {
 	return parsed, nil
 }
 
-func formalUsage(section string) (string, error) {
-	_, _, section = stringPartition(section, ":") // drop "usage:"
-	pu := strings.Fields(section)
+// formalUsageOffsets returns the formal usage of section and, for each byte
+// of it, the offset in section it comes from.
+func formalUsageOffsets(section string) (string, []int, error) {
+	_, _, rest := stringPartition(section, ":") // drop "usage:"
+	base := len(section) - len(rest)
+	pu, offsets := fieldsOffsets(rest)
 
 	if len(pu) == 0 {
-		return "", &LanguageError{"no fields found in usage (perhaps a spacing error)."}
+		return "", nil, &LanguageError{msg: "no fields found in usage (perhaps a spacing error)."}
+	}
+
+	var result strings.Builder
+	var origin []int
+	// emit appends s to the result; synthetic text (not from section) is
+	// attributed to offset.
+	emit := func(s string, offset int, synthetic bool) {
+		result.WriteString(s)
+		for i := range s {
+			if synthetic {
+				origin = append(origin, base+offset)
+			} else {
+				origin = append(origin, base+offset+i)
+			}
+		}
 	}
 
-	result := "( "
-	for _, s := range pu[1:] {
+	emit("( ", offsets[0], true)
+	for i, s := range pu[1:] {
+		offset := offsets[i+1]
 		if s == pu[0] {
-			result += ") | ( "
+			emit(") | ( ", offset, true)
 		} else {
-			result += s + " "
+			emit(s, offset, false)
+			emit(" ", offset+len(s), true)
 		}
 	}
-	result += ")"
+	last := len(pu) - 1
+	emit(")", offsets[last]+len(pu[last]), true)
 
-	return result, nil
+	return result.String(), origin, nil
+}
+
+// usageLines returns the usage lines of section, as split by formalUsage,
+// with white space normalized.
+func usageLines(section string) []string {
+	_, _, section = stringPartition(section, ":") // drop "usage:"
+	pu := strings.Fields(section)
+	var lines []string
+	start := 0
+	for i := 1; i <= len(pu); i++ {
+		if i == len(pu) || pu[i] == pu[0] {
+			lines = append(lines, strings.Join(pu[start:i], " "))
+			start = i
+		}
+	}
+	return lines
 }
 
-func extras(help bool, version string, options patternList, doc string) string {
+// tokenListFromUsage returns the tokens of the formal usage of the usage
+// section of doc, located in doc.
+func tokenListFromUsage(doc string, usage section) (*tokenList, error) {
+	formal, origin, err := formalUsageOffsets(usage.text)
+	if err != nil {
+		var langErr *LanguageError
+		if errors.As(err, &langErr) {
+			langErr.locate(doc, usage.offset)
+		}
+		return nil, err
+	}
+	tokens, offsets := splitPattern(formal)
+	tl := newTokenList(tokens, errorLanguage)
+	tl.doc = doc
+	tl.offsets = make([]int, len(offsets)+1)
+	for i, o := range offsets {
+		tl.offsets[i] = usage.offset + origin[o]
+	}
+	tl.offsets[len(offsets)] = usage.offset + origin[len(origin)-1]
+	return tl, nil
+}
+
+// extras returns the help, as returned by helpDoc, or the version, as
+// returned by versionDoc, if the options ask for it. The options --help-all
+// and --json, if declared, ask for all the help and for the version as JSON.
+// An argument right after -h or --help naming a section of the doc is the
+// topic of the help. If the usage has a command help, so is an argument
+// right after the first argument "help", which alone asks for the help;
+// other arguments are left to the usage, like in "prog help <command>".
+func extras(help, helpCommand bool, declared func(name string) bool, versionDoc func(json bool) string, options patternList, helpDoc func(all bool, topic string) (string, bool)) string {
+	// topic returns the argument at i, if any.
+	topic := func(i int) string {
+		if i < len(options) && options[i].t&patternArgument != 0 {
+			s, _ := options[i].value.(string)
+			return s
+		}
+		return ""
+	}
 	if help {
-		for _, o := range options {
-			if (o.name == "-h" || o.name == "--help") && o.value == true {
-				return strings.Trim(doc, "\n")
+		for i, o := range options {
+			helpAll := o.name == "--help-all" && declared(o.name)
+			if (o.name == "-h" || o.name == "--help" || helpAll) && o.value == true {
+				output, _ := helpDoc(helpAll, topic(i+1))
+				return strings.Trim(output, "\n")
+			}
+		}
+		if helpCommand {
+			i := slices.IndexFunc(options, func(o *pattern) bool { return o.t&patternArgument != 0 })
+			if i >= 0 && options[i].value == "help" {
+				if output, isTopic := helpDoc(false, topic(i+1)); isTopic || topic(i+1) == "" {
+					return strings.Trim(output, "\n")
+				}
 			}
 		}
 	}
-	if version != "" {
+	if versionDoc != nil {
 		for _, o := range options {
 			if (o.name == "--version") && o.value == true {
-				return version
+				json := declared("--json") && slices.ContainsFunc(options, func(o *pattern) bool { return o.name == "--json" && o.value == true })
+				return versionDoc(json)
 			}
 		}
 	}
 	return ""
 }
 
+// fieldsOffsets is like strings.Fields, but also returns the offset of each
+// field in s.
+func fieldsOffsets(s string) ([]string, []int) {
+	var fields []string
+	var offsets []int
+	start := -1
+	for i, r := range s {
+		if unicode.IsSpace(r) {
+			if start >= 0 {
+				fields = append(fields, s[start:i])
+				offsets = append(offsets, start)
+				start = -1
+			}
+		} else if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		fields = append(fields, s[start:])
+		offsets = append(offsets, start)
+	}
+	return fields, offsets
+}
+
 func stringPartition(s, sep string) (string, string, string) {
 	sepPos := strings.Index(s, sep)
 	if sepPos == -1 { // no separator found
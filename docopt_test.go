@@ -22,6 +22,26 @@ import (
 
 var testParser = &Parser{}
 
+// mustParseOption is testParser.parseOption for a valid option description.
+func mustParseOption(t testing.TB, description string) *pattern {
+	t.Helper()
+	o, err := testParser.parseOption(description)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+// mustParseDefaults is testParser.parseDefaults for a valid doc.
+func mustParseDefaults(t testing.TB, doc string) patternList {
+	t.Helper()
+	options, err := testParser.parseDefaults(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return options
+}
+
 func TestPatternFlat(t *testing.T) {
 	q := patternList{
 		newArgument("N", nil),
@@ -46,62 +66,101 @@ func TestPatternFlat(t *testing.T) {
 }
 
 func TestOption(t *testing.T) {
-	if !parseOption("-h").eq(newOption("-h", "", 0, false)) {
+	if !mustParseOption(t, "-h").eq(newOption("-h", "", 0, false)) {
 		t.Fail()
 	}
-	if !parseOption("--help").eq(newOption("", "--help", 0, false)) {
+	if !mustParseOption(t, "--help").eq(newOption("", "--help", 0, false)) {
 		t.Fail()
 	}
-	if !parseOption("-h --help").eq(newOption("-h", "--help", 0, false)) {
+	if !mustParseOption(t, "-h --help").eq(newOption("-h", "--help", 0, false)) {
 		t.Fail()
 	}
-	if !parseOption("-h, --help").eq(newOption("-h", "--help", 0, false)) {
+	if !mustParseOption(t, "-h, --help").eq(newOption("-h", "--help", 0, false)) {
 		t.Fail()
 	}
 
-	if !parseOption("-h TOPIC").eq(newOption("-h", "", 1, false)) {
+	if !mustParseOption(t, "-h TOPIC").eq(newOption("-h", "", 1, false)) {
 		t.Fail()
 	}
-	if !parseOption("--help TOPIC").eq(newOption("", "--help", 1, false)) {
+	if !mustParseOption(t, "--help TOPIC").eq(newOption("", "--help", 1, false)) {
 		t.Fail()
 	}
-	if !parseOption("-h TOPIC --help TOPIC").eq(newOption("-h", "--help", 1, false)) {
+	if !mustParseOption(t, "-h TOPIC --help TOPIC").eq(newOption("-h", "--help", 1, false)) {
 		t.Fail()
 	}
-	if !parseOption("-h TOPIC, --help TOPIC").eq(newOption("-h", "--help", 1, false)) {
+	if !mustParseOption(t, "-h TOPIC, --help TOPIC").eq(newOption("-h", "--help", 1, false)) {
 		t.Fail()
 	}
-	if !parseOption("-h TOPIC, --help=TOPIC").eq(newOption("-h", "--help", 1, false)) {
+	if !mustParseOption(t, "-h TOPIC, --help=TOPIC").eq(newOption("-h", "--help", 1, false)) {
 		t.Fail()
 	}
 
-	if !parseOption("-h  Description...").eq(newOption("-h", "", 0, false)) {
+	if !mustParseOption(t, "-h  Description...").eq(newOption("-h", "", 0, false)) {
 		t.Fail()
 	}
-	if !parseOption("-h --help  Description...").eq(newOption("-h", "--help", 0, false)) {
+	if !mustParseOption(t, "-h --help  Description...").eq(newOption("-h", "--help", 0, false)) {
 		t.Fail()
 	}
-	if !parseOption("-h TOPIC  Description...").eq(newOption("-h", "", 1, false)) {
+	if !mustParseOption(t, "-h TOPIC  Description...").eq(newOption("-h", "", 1, false)) {
 		t.Fail()
 	}
 
-	if !parseOption("    -h").eq(newOption("-h", "", 0, false)) {
+	if !mustParseOption(t, "    -h").eq(newOption("-h", "", 0, false)) {
 		t.Fail()
 	}
 
-	if !parseOption("-h TOPIC  Description... [default: 2]").eq(newOption("-h", "", 1, "2")) {
+	if !mustParseOption(t, "-h TOPIC  Description... [default: 2]").eq(newOption("-h", "", 1, "2")) {
+		t.Fail()
+	}
+	if !mustParseOption(t, "-h TOPIC  Descripton... [default: topic-1]").eq(newOption("-h", "", 1, "topic-1")) {
+		t.Fail()
+	}
+	if !mustParseOption(t, "--help=TOPIC  ... [default: 3.14]").eq(newOption("", "--help", 1, "3.14")) {
 		t.Fail()
 	}
-	if !parseOption("-h TOPIC  Descripton... [default: topic-1]").eq(newOption("-h", "", 1, "topic-1")) {
+	if !mustParseOption(t, "-h, --help=DIR  ... [default: ./]").eq(newOption("-h", "--help", 1, "./")) {
 		t.Fail()
 	}
-	if !parseOption("--help=TOPIC  ... [default: 3.14]").eq(newOption("", "--help", 1, "3.14")) {
+	if !mustParseOption(t, "-h TOPIC  Descripton... [dEfAuLt: 2]").eq(newOption("-h", "", 1, "2")) {
 		t.Fail()
 	}
-	if !parseOption("-h, --help=DIR  ... [default: ./]").eq(newOption("-h", "--help", 1, "./")) {
+	if !mustParseOption(t, "-c <name>=<value>, --config=<name>=<value>").eq(newOption("-c", "--config", 1, nil)) {
+		t.Fail()
+	}
+	if !mustParseOption(t, "-p <x> <y>, --point <x> <y>").eq(newOption("-p", "--point", 2, nil)) {
+		t.Fail()
+	}
+	if !mustParseOption(t, "--point=<x> <y>  [default: 1 2]").eq(newOption("", "--point", 2, []string{"1", "2"})) {
+		t.Fail()
+	}
+
+	negatable := func(short string, value bool) *pattern {
+		p := newOption(short, "--color", 0, value)
+		p.negated = "--no-color"
+		return p
+	}
+	if !mustParseOption(t, "--[no-]color").eq(negatable("", false)) {
 		t.Fail()
 	}
-	if !parseOption("-h TOPIC  Descripton... [dEfAuLt: 2]").eq(newOption("-h", "", 1, "2")) {
+	if !mustParseOption(t, "-c, --[no-]color  Colorize [default: true]").eq(negatable("-c", true)) {
+		t.Fail()
+	}
+	optional := func(short string, implicit string, value any) *pattern {
+		p := newOption(short, "--color", 1, value)
+		p.optional = true
+		p.implicit = implicit
+		return p
+	}
+	if !mustParseOption(t, "--color[=<when>]").eq(optional("", "", nil)) {
+		t.Fail()
+	}
+	if !mustParseOption(t, "-c, --color[=WHEN]  Colorize [implicit: always]").eq(optional("-c", "always", nil)) {
+		t.Fail()
+	}
+	if !mustParseOption(t, "--color[=<when>]  [default: auto] [implicit: always]").eq(optional("", "always", "auto")) {
+		t.Fail()
+	}
+	if !mustParseOption(t, "--[no-]color  Colorize [default: false]").eq(negatable("", false)) {
 		t.Fail()
 	}
 }
@@ -169,19 +228,19 @@ func TestParseArgv(t *testing.T) {
 		newOption("-f", "--file", 1, false),
 	}
 
-	p, err := parseArgv(tokenListFromString(""), &o, false)
+	p, err := testParser.parseArgv(tokenListFromString(""), &o)
 	q := patternList{}
 	if reflect.DeepEqual(p, q) != true {
 		t.Error(err)
 	}
 
-	p, err = parseArgv(tokenListFromString("-h"), &o, false)
+	p, err = testParser.parseArgv(tokenListFromString("-h"), &o)
 	q = patternList{newOption("-h", "", 0, true)}
 	if reflect.DeepEqual(p, q) != true {
 		t.Error(err)
 	}
 
-	p, err = parseArgv(tokenListFromString("-h --verbose"), &o, false)
+	p, err = testParser.parseArgv(tokenListFromString("-h --verbose"), &o)
 	q = patternList{
 		newOption("-h", "", 0, true),
 		newOption("-v", "--verbose", 0, true),
@@ -190,7 +249,7 @@ func TestParseArgv(t *testing.T) {
 		t.Error(err)
 	}
 
-	p, err = parseArgv(tokenListFromString("-h --file f.txt"), &o, false)
+	p, err = testParser.parseArgv(tokenListFromString("-h --file f.txt"), &o)
 	q = patternList{
 		newOption("-h", "", 0, true),
 		newOption("-f", "--file", 1, "f.txt"),
@@ -199,7 +258,7 @@ func TestParseArgv(t *testing.T) {
 		t.Error(err)
 	}
 
-	p, err = parseArgv(tokenListFromString("-h --file f.txt arg"), &o, false)
+	p, err = testParser.parseArgv(tokenListFromString("-h --file f.txt arg"), &o)
 	q = patternList{
 		newOption("-h", "", 0, true),
 		newOption("-f", "--file", 1, "f.txt"),
@@ -209,7 +268,7 @@ func TestParseArgv(t *testing.T) {
 		t.Error(err)
 	}
 
-	p, err = parseArgv(tokenListFromString("-h --file f.txt arg arg2"), &o, false)
+	p, err = testParser.parseArgv(tokenListFromString("-h --file f.txt arg arg2"), &o)
 	q = patternList{
 		newOption("-h", "", 0, true),
 		newOption("-f", "--file", 1, "f.txt"),
@@ -220,7 +279,7 @@ func TestParseArgv(t *testing.T) {
 		t.Error(err)
 	}
 
-	p, err = parseArgv(tokenListFromString("-h arg -- -v"), &o, false)
+	p, err = testParser.parseArgv(tokenListFromString("-h arg -- -v"), &o)
 	q = patternList{
 		newOption("-h", "", 0, true),
 		newArgument("", "arg"),
@@ -239,13 +298,13 @@ func TestParsePattern(t *testing.T) {
 		newOption("-f", "--file", 1, false),
 	}
 
-	p, err := parsePattern("[ -h ]", &o)
+	p, err := testParser.parsePattern("[ -h ]", &o)
 	q := newRequired(newOptional(newOption("-h", "", 0, false)))
 	if p.eq(q) != true {
 		t.Error(err)
 	}
 
-	p, err = parsePattern("[ ARG ... ]", &o)
+	p, err = testParser.parsePattern("[ ARG ... ]", &o)
 	q = newRequired(newOptional(
 		newOneOrMore(
 			newArgument("ARG", nil))))
@@ -253,7 +312,7 @@ func TestParsePattern(t *testing.T) {
 		t.Error(err)
 	}
 
-	p, err = parsePattern("[ -h | -v ]", &o)
+	p, err = testParser.parsePattern("[ -h | -v ]", &o)
 	q = newRequired(
 		newOptional(
 			newEither(
@@ -263,7 +322,7 @@ func TestParsePattern(t *testing.T) {
 		t.Error(err)
 	}
 
-	p, err = parsePattern("( -h | -v [ --file <f> ] )", &o)
+	p, err = testParser.parsePattern("( -h | -v [ --file <f> ] )", &o)
 	q = newRequired(
 		newRequired(
 			newEither(
@@ -276,7 +335,7 @@ func TestParsePattern(t *testing.T) {
 		t.Error(err)
 	}
 
-	p, err = parsePattern("(-h|-v[--file=<f>]N...)", &o)
+	p, err = testParser.parsePattern("(-h|-v[--file=<f>]N...)", &o)
 	q = newRequired(
 		newRequired(
 			newEither(
@@ -291,7 +350,7 @@ func TestParsePattern(t *testing.T) {
 		t.Error(err)
 	}
 
-	p, err = parsePattern("(N [M | (K | L)] | O P)", &o)
+	p, err = testParser.parsePattern("(N [M | (K | L)] | O P)", &o)
 	q = newRequired(
 		newRequired(
 			newEither(
@@ -311,7 +370,7 @@ func TestParsePattern(t *testing.T) {
 		t.Error(err)
 	}
 
-	p, err = parsePattern("[ -h ] [N]", &o)
+	p, err = testParser.parsePattern("[ -h ] [N]", &o)
 	q = newRequired(
 		newOptional(
 			newOption("-h", "", 0, false)),
@@ -321,7 +380,7 @@ func TestParsePattern(t *testing.T) {
 		t.Error(err)
 	}
 
-	p, err = parsePattern("[options]", &o)
+	p, err = testParser.parsePattern("[options]", &o)
 	q = newRequired(
 		newOptional(
 			newOptionsShortcut()))
@@ -329,7 +388,7 @@ func TestParsePattern(t *testing.T) {
 		t.Error(err)
 	}
 
-	p, err = parsePattern("[options] A", &o)
+	p, err = testParser.parsePattern("[options] A", &o)
 	q = newRequired(
 		newOptional(
 			newOptionsShortcut()),
@@ -338,7 +397,7 @@ func TestParsePattern(t *testing.T) {
 		t.Error(err)
 	}
 
-	p, err = parsePattern("-v [options]", &o)
+	p, err = testParser.parsePattern("-v [options]", &o)
 	q = newRequired(
 		newOption("-v", "--verbose", 0, false),
 		newOptional(
@@ -347,19 +406,19 @@ func TestParsePattern(t *testing.T) {
 		t.Error(err)
 	}
 
-	p, err = parsePattern("ADD", &o)
+	p, err = testParser.parsePattern("ADD", &o)
 	q = newRequired(newArgument("ADD", nil))
 	if p.eq(q) != true {
 		t.Error(err)
 	}
 
-	p, err = parsePattern("<add>", &o)
+	p, err = testParser.parsePattern("<add>", &o)
 	q = newRequired(newArgument("<add>", nil))
 	if p.eq(q) != true {
 		t.Error(err)
 	}
 
-	p, err = parsePattern("add", &o)
+	p, err = testParser.parsePattern("add", &o)
 	q = newRequired(newCommand("add", false))
 	if p.eq(q) != true {
 		t.Error(err)
@@ -723,6 +782,37 @@ func TestEitherMatch(t *testing.T) {
 	}
 }
 
+func TestEitherMatchPrecedence(t *testing.T) {
+	// The alternative leaving the fewest elements wins, whatever its position.
+	i, v, w, x, _ := newEither(
+		newArgument("A", nil),
+		newRequired(newArgument("A", nil), newArgument("B", nil), newArgument("C", nil)),
+		newRequired(newArgument("A", nil), newArgument("B", nil))).matchEither(
+		&patternList{newArgument("", 1), newArgument("", 2), newArgument("", 3)}, nil, nil)
+	qt.Assert(t, qt.Equals(i, 1))
+	qt.Assert(t, qt.IsTrue(v))
+	qt.Assert(t, qt.IsTrue(reflect.DeepEqual(*w, patternList{})))
+	qt.Assert(t, qt.IsTrue(reflect.DeepEqual(*x, patternList{
+		newArgument("A", 1), newArgument("B", 2), newArgument("C", 3),
+	})))
+
+	// On a tie, the first alternative wins.
+	i, v, w, x, _ = newEither(
+		newArgument("N", nil),
+		newRequired(newArgument("N", nil), newArgument("M", nil)),
+		newRequired(newArgument("M", nil), newArgument("N", nil))).matchEither(
+		&patternList{newArgument("", 1), newArgument("", 2)}, nil, nil)
+	qt.Assert(t, qt.Equals(i, 1))
+	qt.Assert(t, qt.IsTrue(v))
+	qt.Assert(t, qt.IsTrue(reflect.DeepEqual(*w, patternList{})))
+	qt.Assert(t, qt.IsTrue(reflect.DeepEqual(*x,
+		patternList{newArgument("N", 1), newArgument("M", 2)})))
+
+	i, v, _, _, _ = newEither(newArgument("N", nil)).matchEither(&patternList{}, nil, nil)
+	qt.Assert(t, qt.Equals(i, -1))
+	qt.Assert(t, qt.IsFalse(v))
+}
+
 func TestOneOrMoreMatch(t *testing.T) {
 	v, w, x := newOneOrMore(newArgument("N", nil)).match(
 		&patternList{newArgument("", 9)}, nil)
@@ -1052,6 +1142,63 @@ func TestPatternFixRepeatingArguments(t *testing.T) {
 	}
 }
 
+// fixRepeatingArgumentsTransform is the original implementation of
+// fixRepeatingArguments, based on the full expansion of transform.
+func fixRepeatingArgumentsTransform(p *pattern) {
+	var either []patternList
+
+	for _, child := range p.transform().children {
+		either = append(either, child.children)
+	}
+	for _, cas := range either {
+		casMultiple := patternList{}
+		for _, e := range cas {
+			if cas.count(e) > 1 {
+				casMultiple = append(casMultiple, e)
+			}
+		}
+		for _, e := range casMultiple {
+			e.setRepeating()
+		}
+	}
+}
+
+// usagePattern returns the pattern of doc, with identities fixed.
+func usagePattern(t testing.TB, doc string) *pattern {
+	formal, err := formalUsage(parseSection("usage:", doc)[0])
+	qt.Assert(t, qt.IsNil(err))
+	options := mustParseDefaults(t, doc)
+	pat, err := testParser.parsePattern(formal, &options)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.IsNil(pat.fixIdentities(nil)))
+	return pat
+}
+
+func TestFixRepeatingArgumentsSameAsTransform(t *testing.T) {
+	raw, err := os.ReadFile("testcases.docopt")
+	qt.Assert(t, qt.IsNil(err))
+	tests, err := parseTest(raw)
+	qt.Assert(t, qt.IsNil(err))
+
+	docs := []string{
+		"usage: prog [-a | -b] [-a | -c] [-b | -c] <x> [<x> | <y>]",
+		"usage: prog (<x> (<y> | <x> <x>)...)",
+		"usage: prog ([-v] [-v] | (-q [-q]) | go go) [go]",
+	}
+	for _, c := range tests {
+		if len(docs) == 0 || docs[len(docs)-1] != c.doc {
+			docs = append(docs, c.doc)
+		}
+	}
+	for _, doc := range docs {
+		want := usagePattern(t, doc)
+		fixRepeatingArgumentsTransform(want)
+		have := usagePattern(t, doc)
+		have.fixRepeatingArguments()
+		qt.Check(t, qt.IsTrue(have.eq(want)), qt.Commentf("doc: %s", doc))
+	}
+}
+
 func TestSet(t *testing.T) {
 	p := newArgument("N", nil)
 	q := newArgument("N", nil)
@@ -1137,6 +1284,202 @@ func TestLongOptionsErrorHandling(t *testing.T) {
 	}
 }
 
+func TestLongOptionsAbbreviation(t *testing.T) {
+	doc := "Usage: prog [--verbose] [--color]"
+	var warnings []string
+	parser := &Parser{Warn: func(msg string) { warnings = append(warnings, msg) }}
+
+	v, err := parser.Parse(doc, []string{"--verb", "--color"}, "")
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(v, Opts{"--verbose": true, "--color": true}))
+	qt.Assert(t, qt.DeepEquals(warnings, []string{"--verb is an abbreviation of --verbose"}))
+
+	parser.MinAbbrevLen = 4
+	_, err = parser.Parse(doc, []string{"--ver"}, "")
+	qt.Assert(t, qt.ErrorAs(err, new(*UserError)))
+	qt.Assert(t, qt.ErrorMatches(err, "unknown option: --ver"))
+	_, err = parser.Parse(doc, []string{"--verb"}, "")
+	qt.Assert(t, qt.IsNil(err))
+
+	parser.NoAbbrev = true
+	_, err = parser.Parse(doc, []string{"--verbos"}, "")
+	qt.Assert(t, qt.ErrorAs(err, new(*UserError)))
+	v, err = parser.Parse(doc, []string{"--verbose"}, "")
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(v, Opts{"--verbose": true, "--color": false}))
+}
+
+func TestModeEnv(t *testing.T) {
+	doc := "Usage: prog [-v] [<args>...]"
+	parser := &Parser{Mode: ModeEnv}
+
+	t.Setenv("POSIXLY_CORRECT", "1")
+	v, err := parser.Parse(doc, []string{"a", "-v"}, "")
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(v, Opts{"-v": false, "<args>": []string{"a", "-v"}}))
+
+	// Set but empty is as not set.
+	t.Setenv("POSIXLY_CORRECT", "")
+	v, err = parser.Parse(doc, []string{"a", "-v"}, "")
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(v, Opts{"-v": true, "<args>": []string{"a"}}))
+}
+
+func TestNegatableOptionInvalidDefault(t *testing.T) {
+	doc := "Usage: prog [options]\n\nOptions:\n  --[no-]color  Colorize [default: yes]."
+	_, err := testParser.Parse(doc, []string{}, "")
+	var langErr *LanguageError
+	qt.Assert(t, qt.ErrorAs(err, &langErr))
+	qt.Assert(t, qt.Equals(langErr.Line, 4))
+	qt.Assert(t, qt.ErrorMatches(err, `line 4, column 3: --color: default must be true or false, got: yes[\s\S]*`))
+}
+
+func TestOptionPrefixes(t *testing.T) {
+	doc := `Usage: prog [options] <file>
+
+Options:
+  +v, --verbose  Be verbose.
+  -v             Be quiet.
+  +o <out>       Output.`
+	parser := &Parser{ShortPrefixes: "-+"}
+
+	v, err := parser.Parse(doc, []string{"+vo", "x", "f", "-v"}, "")
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(v, Opts{"--verbose": true, "-v": true, "+o": "x", "<file>": "f"}))
+
+	v, err = parser.Parse(doc, []string{"+", "--verb"}, "")
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(v, Opts{"--verbose": true, "-v": false, "+o": nil, "<file>": "+"}))
+
+	doc = `Usage: prog [/q] [/out=<file>] [-v] [--] <path>
+
+Options:
+  /q             Quiet.
+  /out=<file>    Output.`
+	parser = &Parser{LongPrefixes: []string{"--", "/"}}
+
+	v, err = parser.Parse(doc, []string{"/q", "/ou=x", "-v", "--", "/tmp"}, "")
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(v, Opts{"/q": true, "/out": "x", "-v": true, "--": true, "<path>": "/tmp"}))
+
+	_, err = parser.Parse(doc, []string{"/tmp"}, "")
+	qt.Assert(t, qt.ErrorAs(err, new(*UserError)))
+	qt.Assert(t, qt.ErrorMatches(err, "unknown option: /tmp"))
+
+	// The regexp of the option descriptions is compiled once per prefix set.
+	qt.Assert(t, qt.Equals(parser.optionDescriptionRegexp(), (&Parser{LongPrefixes: []string{"--", "/"}}).optionDescriptionRegexp()))
+
+	// Negatable flags use the configured long prefixes too.
+	doc = `Usage: prog [options]
+
+Options:
+  /[no-]color    Colorize [default: true].`
+	v, err = parser.Parse(doc, []string{"/no-color"}, "")
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(v, Opts{"/color": false}))
+	v, err = parser.Parse(doc, []string{}, "")
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(v, Opts{"/color": true}))
+
+	// --name needs "--" among the long prefixes, in the usage and in the
+	// options sections.
+	parser = &Parser{LongPrefixes: []string{"/"}}
+	_, err = parser.Parse("Usage: prog [--x]", []string{}, "")
+	qt.Assert(t, qt.ErrorAs(err, new(*LanguageError)))
+	qt.Assert(t, qt.ErrorMatches(err, `line 1, column 14: --x: -- is not a long prefix[\s\S]*`))
+	_, err = parser.Parse("Usage: prog [options]\n\nOptions:\n  --x  X.", []string{}, "")
+	qt.Assert(t, qt.ErrorAs(err, new(*LanguageError)))
+	qt.Assert(t, qt.ErrorMatches(err, `line 4, column 3: --x: -- is not a long prefix[\s\S]*`))
+	v, err = parser.Parse("Usage: prog [/x] [--]", []string{"--"}, "")
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(v, Opts{"/x": false, "--": true}))
+}
+
+func TestOptionsRequireArguments(t *testing.T) {
+	doc := "Usage: prog [options]\nOptions:\n  -r <w> <h>, --resize <w> <h>\n  -o <file>"
+
+	_, err := testParser.Parse(doc, []string{"--resize", "1"}, "")
+	qt.Assert(t, qt.ErrorAs(err, new(*UserError)))
+	qt.Assert(t, qt.ErrorMatches(err, "--resize requires 2 arguments"))
+
+	_, err = testParser.Parse(doc, []string{"-r1"}, "")
+	qt.Assert(t, qt.ErrorMatches(err, "-r requires 2 arguments"))
+
+	_, err = testParser.Parse(doc, []string{"-o"}, "")
+	qt.Assert(t, qt.ErrorMatches(err, "-o requires argument"))
+}
+
+func TestHiddenAndDeprecatedOptions(t *testing.T) {
+	doc := `Usage: prog [options]
+
+Options:
+  -h, --help     Show help.
+  --new <n>      New name.
+  --old <n>      Old name [deprecated: use --new] [hidden]
+  -q             Quiet, use --quiet
+                 instead [deprecated] [hidden]
+  --quiet        Quiet.`
+	var stdout, stderr strings.Builder
+	parser := &Parser{Stdout: &stdout, Stderr: &stderr}
+
+	v, err := parser.Parse(doc, []string{"--old", "a", "-q"}, "")
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(v, Opts{"--help": false, "--new": nil, "--old": "a", "-q": true, "--quiet": false}))
+	qt.Assert(t, qt.Equals(stderr.String(),
+		"warning: --old is deprecated: use --new\nwarning: -q is deprecated\n"))
+
+	var warnings []string
+	parser.Warn = func(msg string) { warnings = append(warnings, msg) }
+	_, err = parser.Parse(doc, []string{"--old", "b"}, "")
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(warnings, []string{"--old is deprecated: use --new"}))
+
+	_, err = parser.Parse(doc, []string{"--help"}, "")
+	qt.Assert(t, qt.ErrorIs(err, ErrHelp))
+	qt.Assert(t, qt.Equals(stdout.String(), `Usage: prog [options]
+
+Options:
+  -h, --help     Show help.
+  --new <n>      New name.
+  --quiet        Quiet.
+`))
+}
+
+func TestMustParseExit(t *testing.T) {
+	doc := "Usage: prog [-h] [--version] <name>"
+	testCases := []struct {
+		argv     []string
+		usage    int
+		wantCode int
+	}{
+		{[]string{"a"}, 0, -1},
+		{[]string{"-h"}, 0, 0},
+		{[]string{"--version"}, 0, 0},
+		{[]string{}, 0, 2},
+		{[]string{"a", "b"}, 64, 64},
+	}
+	for _, tc := range testCases {
+		code := -1
+		var stdout, stderr strings.Builder
+		parser := &Parser{
+			Exit:          func(c int) { code = c },
+			UsageExitCode: tc.usage,
+			Stdout:        &stdout,
+			Stderr:        &stderr,
+		}
+		opts := parser.MustParse(doc, tc.argv, "1.0")
+		qt.Assert(t, qt.Equals(code, tc.wantCode), qt.Commentf("argv: %q", tc.argv))
+		qt.Assert(t, qt.Equals(opts != nil, tc.wantCode == -1), qt.Commentf("argv: %q", tc.argv))
+	}
+
+	code := -1
+	var stderr strings.Builder
+	parser := &Parser{Exit: func(c int) { code = c }, Stderr: &stderr}
+	qt.Assert(t, qt.IsNil(parser.MustParse("Usage: prog (", []string{}, "")))
+	qt.Assert(t, qt.Equals(code, 1))
+	qt.Assert(t, qt.Not(qt.Equals(stderr.String(), "")))
+}
+
 func TestShortOptionsErrorHandling(t *testing.T) {
 	_, err := testParser.Parse("Usage: prog -x\nOptions: -x  this\n -x  that", []string{}, "")
 	if _, ok := err.(*LanguageError); !ok {
@@ -1260,6 +1603,170 @@ func TestLanguageErrors(t *testing.T) {
 	}
 }
 
+func TestLanguageErrorPosition(t *testing.T) {
+	doc := `Usage:
+  prog [-v] A
+  prog B [C
+
+Options:
+  -v  Verbose.`
+
+	_, err := testParser.Parse(doc, []string{}, "")
+
+	var langErr *LanguageError
+	qt.Assert(t, qt.ErrorAs(err, &langErr))
+	qt.Assert(t, qt.Equals(langErr.Line, 3))
+	qt.Assert(t, qt.Equals(langErr.Column, 10))
+	qt.Assert(t, qt.Equals(langErr.Excerpt, "  prog B [C\n         ^"))
+	qt.Assert(t, qt.Equals(err.Error(),
+		"line 3, column 10: unmatched '[', expected: ']' got: ')'"))
+
+	_, err = testParser.Parse("usage: prog a ) ) b", []string{}, "")
+	qt.Assert(t, qt.ErrorAs(err, &langErr))
+	qt.Assert(t, qt.Equals(langErr.Line, 1))
+	qt.Assert(t, qt.Equals(langErr.Column, 17))
+
+	_, err = testParser.Parse("usage: prog\n\tprog\t-x\noptions: -x  this\n -x  that",
+		[]string{}, "")
+	qt.Assert(t, qt.ErrorAs(err, &langErr))
+	qt.Assert(t, qt.Equals(langErr.Line, 2))
+	qt.Assert(t, qt.Equals(langErr.Excerpt, "\tprog\t-x\n\t    \t^"))
+
+	_, err = testParser.Parse("no usage with colon here", []string{}, "")
+	qt.Assert(t, qt.ErrorAs(err, &langErr))
+	qt.Assert(t, qt.Equals(langErr.Line, 0))
+	qt.Assert(t, qt.Equals(langErr.Excerpt, ""))
+}
+
+func TestSplitPatternOffsets(t *testing.T) {
+	tokens, offsets := splitPattern("( [-h] <a b>... | x )")
+
+	qt.Assert(t, qt.DeepEquals(tokens,
+		[]string{"(", "[", "-h", "]", "<a b>", "...", "|", "x", ")"}))
+	qt.Assert(t, qt.DeepEquals(offsets, []int{0, 2, 3, 5, 7, 12, 16, 18, 20}))
+
+	tokens, offsets = splitPattern("[--color[=<when>]] [--page[=N]]")
+
+	qt.Assert(t, qt.DeepEquals(tokens,
+		[]string{"[", "--color[=<when>]", "]", "[", "--page[=N]", "]"}))
+	qt.Assert(t, qt.DeepEquals(offsets, []int{0, 1, 17, 19, 20, 30}))
+}
+
+func TestParseResultUsage(t *testing.T) {
+	doc := `Usage:
+  prog go [<a>]
+  prog go <b> [<c>]
+  prog <a>
+  prog <b>
+  prog <a> <b> [<c>]`
+
+	for _, tc := range []struct {
+		argv      []string
+		wantUsage int
+		wantOpts  Opts
+	}{
+		{
+			// Tie between lines 0, 1 and 4.
+			argv:      []string{"go", "x"},
+			wantUsage: 0,
+			wantOpts:  Opts{"<a>": "x", "<b>": nil, "<c>": nil, "go": true},
+		},
+		{
+			// Tie between lines 1 and 4.
+			argv:      []string{"go", "x", "y"},
+			wantUsage: 1,
+			wantOpts:  Opts{"<a>": nil, "<b>": "x", "<c>": "y", "go": true},
+		},
+		{
+			// Tie between lines 2 and 3.
+			argv:      []string{"x"},
+			wantUsage: 2,
+			wantOpts:  Opts{"<a>": "x", "<b>": nil, "<c>": nil, "go": false},
+		},
+		{
+			argv:      []string{"x", "y"},
+			wantUsage: 4,
+			wantOpts:  Opts{"<a>": "x", "<b>": "y", "<c>": nil, "go": false},
+		},
+	} {
+		res, err := testParser.ParseResult(doc, tc.argv, "")
+		qt.Assert(t, qt.IsNil(err))
+		qt.Check(t, qt.Equals(res.Usage, tc.wantUsage), qt.Commentf("argv: %q", tc.argv))
+		qt.Check(t, qt.DeepEquals(res.Opts, tc.wantOpts), qt.Commentf("argv: %q", tc.argv))
+	}
+
+	res, err := testParser.ParseResult("usage: prog [-v] (a | b)", []string{"b"}, "")
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(res.Usage, 0))
+}
+
+func TestParseResultUsageLine(t *testing.T) {
+	doc := `Naval Fate.
+
+Usage:
+  naval_fate ship new <name>...
+  naval_fate ship <name> move <x> <y>
+             [--speed=<kn>]
+  naval_fate ship shoot <x> <y>
+
+Options:
+  --speed=<kn>  Speed in knots [default: 10].`
+	parser := &Parser{UsageNames: []string{"new", "move"}}
+
+	res, err := parser.ParseResult(doc, []string{"ship", "Guardian", "move", "1", "2"}, "")
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(res.Usage, 1))
+	qt.Assert(t, qt.Equals(res.UsageLine, "naval_fate ship <name> move <x> <y> [--speed=<kn>]"))
+	qt.Assert(t, qt.Equals(res.UsageName, "move"))
+
+	// Not all lines need a name.
+	res, err = parser.ParseResult(doc, []string{"ship", "shoot", "1", "2"}, "")
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(res.Usage, 2))
+	qt.Assert(t, qt.Equals(res.UsageLine, "naval_fate ship shoot <x> <y>"))
+	qt.Assert(t, qt.Equals(res.UsageName, ""))
+}
+
+func TestParseResultEvents(t *testing.T) {
+	doc := `usage: prog run [-v] (-i <in> -o <out>)... [--] [<rest>...]
+
+options:
+  -v         Verbose.
+  -i <in>    Input.
+  -o <out>   Output.`
+
+	res, err := testParser.ParseResult(doc,
+		[]string{"-vi", "a", "run", "-o", "x", "-ib", "-oy", "--", "-z"}, "")
+
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(res.Events, []Event{
+		{Index: 0, Key: "-v", Value: true},
+		{Index: 0, Key: "-i", Value: "a"},
+		{Index: 2, Key: "run", Value: true},
+		{Index: 3, Key: "-o", Value: "x"},
+		{Index: 5, Key: "-i", Value: "b"},
+		{Index: 6, Key: "-o", Value: "y"},
+		{Index: 7, Key: "--", Value: true},
+		{Index: 8, Key: "<rest>", Value: "-z"},
+	}))
+
+	doc = `usage: prog [options]
+
+options:
+  -r <from> <to>      Range.
+  --color[=<when>]    Colorize [implicit: always].
+  --[no-]pager        Page.`
+
+	res, err = testParser.ParseResult(doc, []string{"-r", "1", "2", "--color", "--no-pager"}, "")
+
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(res.Events, []Event{
+		{Index: 0, Key: "-r", Value: []string{"1", "2"}},
+		{Index: 3, Key: "--color", Value: "always"},
+		{Index: 4, Key: "--pager", Value: false},
+	}))
+}
+
 func TestIssue40ForkErrHelp(t *testing.T) {
 	doc := "usage: prog --help-commands | --help"
 
@@ -1342,6 +1849,16 @@ func TestDefaultValueForPositionalArguments(t *testing.T) {
 	if v, err := testParser.Parse(doc, []string{"--data=this"}, ""); reflect.DeepEqual(v, Opts{"--data": []string{"this"}}) != true {
 		t.Error(err)
 	}
+
+	doc = "Usage: prog [<data>...]\nArguments:\n\t<data>    Input data [default: x y]"
+	if v, err := testParser.Parse(doc, []string{}, ""); reflect.DeepEqual(v, Opts{"<data>": []string{"x", "y"}}) != true {
+		t.Error(err)
+	}
+
+	doc = "Usage: prog [<data>...]\nArguments:\n\t<data>    Input data [default: x y]"
+	if v, err := testParser.Parse(doc, []string{"this"}, ""); reflect.DeepEqual(v, Opts{"<data>": []string{"this"}}) != true {
+		t.Error(err)
+	}
 }
 
 func TestIssue59(t *testing.T) {
@@ -1464,12 +1981,17 @@ usage: pit stop`
 	if reflect.DeepEqual(v, w) != true {
 		t.Fail()
 	}
+	v = parseSection("", "Prog.\n\nUsage: prog\n\nOptions:\n  -v  Verbose.\n\nExamples:\n  prog -v\n")
+	w = []string{"Usage: prog", "Options:\n  -v  Verbose.", "Examples:\n  prog -v"}
+	if reflect.DeepEqual(v, w) != true {
+		t.Errorf("got %q", v)
+	}
 }
 
 func TestIssue126DefaultsNotParsedCorrectlyWhenTabs(t *testing.T) {
 	section := "Options:\n\t--foo=<arg>  [default: bar]"
 	v := patternList{newOption("", "--foo", 1, "bar")}
-	if reflect.DeepEqual(parseDefaults(section), v) != true {
+	if reflect.DeepEqual(mustParseDefaults(t, section), v) != true {
 		t.Fail()
 	}
 }
@@ -1487,8 +2009,20 @@ func TestFileTestcases(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
+		modes := map[string]Mode{"": ModeGNU, "gnu": ModeGNU, "posix": ModePOSIX, "env": ModeEnv}
 		for _, c := range tests {
-			result, err := testParser.Parse(c.doc, c.argv, "")
+			mode, ok := modes[c.mode]
+			if !ok {
+				t.Fatal("testcase:", c.id, "unknown mode:", c.mode)
+			}
+			// Sections "# mode: env" expect POSIXLY_CORRECT to be set.
+			if mode == ModeEnv {
+				t.Setenv("POSIXLY_CORRECT", "1")
+			} else {
+				t.Setenv("POSIXLY_CORRECT", "")
+			}
+			parser := &Parser{Mode: mode}
+			result, err := parser.Parse(c.doc, c.argv, "")
 			if _, ok := err.(*UserError); c.userError && !ok {
 				// expected a user-error
 				t.Error("testcase:", c.id, "result:", result, "error:", err)
@@ -1509,9 +2043,30 @@ type testcase struct {
 	argv      []string
 	expect    Opts
 	userError bool
+	mode      string // as set by the last "# mode: name" line, if any
 }
 
 func parseTest(raw []byte) ([]testcase, error) {
+	var res []testcase
+	// A line "# mode: name" sets the mode of the test cases that follow.
+	modePattern := regexp.MustCompile(`(?m)^# mode: (\w+)$`)
+	modes := []string{""}
+	for _, m := range modePattern.FindAllSubmatch(raw, -1) {
+		modes = append(modes, string(m[1]))
+	}
+	id := 0
+	for i, section := range modePattern.Split(string(raw), -1) {
+		cases, err := parseTestSection([]byte(section), modes[i], id)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, cases...)
+		id += len(cases)
+	}
+	return res, nil
+}
+
+func parseTestSection(raw []byte, mode string, id int) ([]testcase, error) {
 	var res []testcase
 	commentPattern := regexp.MustCompile("#.*")
 	raw = commentPattern.ReplaceAll(raw, []byte(""))
@@ -1520,7 +2075,6 @@ func parseTest(raw []byte) ([]testcase, error) {
 		raw = raw[3:]
 	}
 
-	id := 0
 	for _, fixture := range bytes.Split(raw, []byte(`r"""`)) {
 		doc, _, body := stringPartition(string(fixture), `"""`)
 		for _, cas := range strings.Split(body, "$")[1:] {
@@ -1537,25 +2091,34 @@ func parseTest(raw []byte) ([]testcase, error) {
 			}
 			switch expect := expectUntyped.(type) {
 			case string: // user-error
-				res = append(res, testcase{id, doc, prog, argv, nil, true})
+				res = append(res, testcase{id, doc, prog, argv, nil, true, mode})
 			case map[string]any:
-				// convert []any values to []string
+				// convert []any values to []string, or [][]string if nested
 				// convert float64 values to int
 				for k, vUntyped := range expect {
 					switch v := vUntyped.(type) {
 					case []any:
-						itemList := make([]string, len(v))
-						for i, itemUntyped := range v {
-							if item, ok := itemUntyped.(string); ok {
-								itemList[i] = item
+						expect[k] = stringList(v)
+						if len(v) > 0 {
+							if _, ok := v[0].([]any); ok {
+								lists := make([][]string, len(v))
+								for i, item := range v {
+									lists[i] = stringList(item.([]any))
+								}
+								expect[k] = lists
 							}
 						}
-						expect[k] = itemList
+					case map[string]any:
+						m := make(map[string]string, len(v))
+						for mk, mv := range v {
+							m[mk], _ = mv.(string)
+						}
+						expect[k] = m
 					case float64:
 						expect[k] = int(v)
 					}
 				}
-				res = append(res, testcase{id, doc, prog, argv, expect, false})
+				res = append(res, testcase{id, doc, prog, argv, expect, false, mode})
 			default:
 				return nil, fmt.Errorf("unhandled json data type")
 			}
@@ -1564,3 +2127,14 @@ func parseTest(raw []byte) ([]testcase, error) {
 	}
 	return res, nil
 }
+
+// stringList converts the strings of a JSON list.
+func stringList(list []any) []string {
+	itemList := make([]string, len(list))
+	for i, itemUntyped := range list {
+		if item, ok := itemUntyped.(string); ok {
+			itemList[i] = item
+		}
+	}
+	return itemList
+}
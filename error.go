@@ -1,6 +1,11 @@
 package docopt
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
 
 // ErrHelp can be used in client code to decide when to call os.Exit(0) as opposed
 // to os.Exit(1). For example:
@@ -27,10 +32,51 @@ func (e UserError) Error() string {
 }
 
 // LanguageError records an error with the doc string.
+//
+// When the error can be traced back to a specific place in the doc, Line and
+// Column (both starting at 1) locate it and Excerpt shows the offending doc
+// line with a caret below the column, for example:
+//
+//	prog [--verbose <file>
+//	     ^
+//
+// Otherwise Line and Column are 0 and Excerpt is empty.
 type LanguageError struct {
-	msg string
+	msg     string
+	Line    int
+	Column  int
+	Excerpt string
 }
 
 func (e LanguageError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.msg)
+	}
 	return e.msg
 }
+
+// locate sets the position of e to the byte offset in doc.
+func (e *LanguageError) locate(doc string, offset int) {
+	if offset < 0 || offset > len(doc) {
+		return
+	}
+	lineStart := strings.LastIndex(doc[:offset], "\n") + 1
+	lineEnd := strings.Index(doc[offset:], "\n")
+	if lineEnd == -1 {
+		lineEnd = len(doc)
+	} else {
+		lineEnd += offset
+	}
+	e.Line = strings.Count(doc[:offset], "\n") + 1
+	e.Column = utf8.RuneCountInString(doc[lineStart:offset]) + 1
+
+	// Keep the tabs of the doc line in the caret line, so that the caret
+	// stays aligned whatever the tab width.
+	caret := strings.Map(func(r rune) rune {
+		if r == '\t' {
+			return r
+		}
+		return ' '
+	}, doc[lineStart:offset])
+	e.Excerpt = doc[lineStart:lineEnd] + "\n" + caret + "^"
+}
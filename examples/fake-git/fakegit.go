@@ -20,11 +20,11 @@ func run(args []string) error {
 	usage := `usage: git [--version] [--exec-path=<path>] [--html-path]
            [-p|--paginate|--no-pager] [--no-replace-objects]
            [--bare] [--git-dir=<path>] [--work-tree=<path>]
-           [-c <name>=<value>] [--help]
+           [-c <name=value>]... [--help]
            <command> [<args>...]
 
 options:
-   -c <name=value>
+   -c <name=value>  Pass a configuration parameter to the command [map]
    -h, --help
    -p, --paginate
 
@@ -66,6 +66,9 @@ func run(args []string) error {
 	case "serial":
 		return cmdSerial(subArgs, cfg.Timeout)
 	default:
+		if s := parser.SuggestCommand(usage, cfg.Command); s != "" {
+			return fmt.Errorf("unknown command: %s, did you mean %s?", cfg.Command, s)
+		}
 		return fmt.Errorf("unknown command: %s", cfg.Command)
 	}
 }
@@ -5,13 +5,13 @@ go 1.21
 require (
 	github.com/go-quicktest/qt v1.101.0
 	github.com/rogpeppe/go-internal v1.11.0
+	golang.org/x/exp v0.0.0-20231127185646-65229373498e
 )
 
 require (
 	github.com/google/go-cmp v0.5.9 // indirect
 	github.com/kr/pretty v0.3.1 // indirect
 	github.com/kr/text v0.2.0 // indirect
-	golang.org/x/exp v0.0.0-20231127185646-65229373498e // indirect
 	golang.org/x/sys v0.0.0-20220722155257-8c9f86f7a55f // indirect
 	golang.org/x/tools v0.16.0 // indirect
 )
@@ -0,0 +1,370 @@
+package docopt
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Help returns the help that Parse prints when the user asks for it. The
+// error, a LanguageError, is for an invalid options section.
+func (p *Parser) Help(doc string) (string, error) {
+	options, err := p.parseDefaults(doc)
+	if err != nil {
+		return "", err
+	}
+	return strings.Trim(p.help(doc, options), "\n"), nil
+}
+
+// help returns doc as help, given its options. See Parser.FormatHelp.
+func (p *Parser) help(doc string, options patternList) string {
+	if p.FormatHelp {
+		return p.formatOptions(doc)
+	}
+	if !slices.ContainsFunc(options, func(o *pattern) bool { return o.hidden }) {
+		return doc
+	}
+	return p.replaceOptions(doc, func(desc section) (string, bool) {
+		return "", reHidden.MatchString(desc.text)
+	})
+}
+
+// descriptions returns the descriptions of the options and of the arguments
+// of doc, in order.
+func (p *Parser) descriptions(doc string) []section {
+	var descs []section
+	for _, desc := range p.optionDescriptions(doc) {
+		descs = append(descs, desc.section)
+	}
+	descs = append(descs, argumentDescriptions(doc)...)
+	slices.SortFunc(descs, func(a, b section) int { return a.offset - b.offset })
+	return descs
+}
+
+// replaceOptions returns doc with the option and argument descriptions for
+// which replace returns true replaced by the string it returns. The replaced
+// text includes the indentation of a description on a line of its own; an
+// empty string removes the whole line.
+func (p *Parser) replaceOptions(doc string, replace func(desc section) (string, bool)) string {
+	var b strings.Builder
+	prev := 0
+	for _, desc := range p.descriptions(doc) {
+		repl, ok := replace(desc)
+		if !ok {
+			continue
+		}
+		start := desc.offset
+		lineStart, ownLine := descriptionLine(doc, start)
+		if ownLine {
+			start = lineStart
+		}
+		b.WriteString(doc[prev:start])
+		if ownLine && repl != "" {
+			b.WriteString("\n")
+		}
+		b.WriteString(repl)
+		prev = desc.offset + len(desc.text)
+	}
+	b.WriteString(doc[prev:])
+	return b.String()
+}
+
+// descriptionLine returns the start of the line of the description at
+// offset in doc, and whether the description is on a line of its own rather
+// than after a heading, like "Options: --foo  Foo.".
+func descriptionLine(doc string, offset int) (int, bool) {
+	lineStart := strings.LastIndexByte(doc[:offset], '\n')
+	return lineStart, lineStart >= 0 && strings.TrimSpace(doc[lineStart:offset]) == ""
+}
+
+const (
+	helpIndent = 2 // of the option names
+	helpGap    = 2 // between the option names and the description
+)
+
+// formatOptions returns doc with the descriptions of its options and
+// arguments aligned in a column and wrapped to the help width, and without
+// the hidden options. Descriptions after a heading are left as they are.
+func (p *Parser) formatOptions(doc string) string {
+	width := p.helpWidth()
+	column := 0
+	for _, desc := range p.descriptions(doc) {
+		if _, ownLine := descriptionLine(doc, desc.offset); !ownLine || reHidden.MatchString(desc.text) {
+			continue
+		}
+		names, _ := splitOptionDescription(desc.text)
+		column = max(column, helpIndent+utf8.RuneCountInString(names)+helpGap)
+	}
+	// Options with longer names start their description on the next line.
+	column = min(column, width/2)
+	return p.replaceOptions(doc, func(desc section) (string, bool) {
+		if reHidden.MatchString(desc.text) {
+			return "", true
+		}
+		if _, ownLine := descriptionLine(doc, desc.offset); !ownLine {
+			return "", false
+		}
+		names, description := splitOptionDescription(desc.text)
+		return formatOption(names, description, column, width), true
+	})
+}
+
+// splitOptionDescription returns the option names (and arguments) of an
+// option description and the description, with white space normalized.
+func splitOptionDescription(text string) (string, string) {
+	names, _, description := stringPartition(strings.TrimSpace(text), "  ")
+	return strings.TrimSpace(names), strings.Join(strings.Fields(description), " ")
+}
+
+// formatOption returns the lines of an option, with the description starting
+// at column and wrapped to width.
+func formatOption(names, description string, column, width int) string {
+	var b strings.Builder
+	line := strings.Repeat(" ", helpIndent) + names
+	if description == "" {
+		return line
+	}
+	lineLen := utf8.RuneCountInString(line)
+	if lineLen+helpGap > column {
+		b.WriteString(line + "\n")
+		line, lineLen = "", 0
+	}
+	line += strings.Repeat(" ", column-lineLen)
+	lineLen = column
+	for i, word := range strings.Fields(description) {
+		wordLen := utf8.RuneCountInString(word)
+		if i > 0 && lineLen+1+wordLen > width {
+			b.WriteString(line + "\n")
+			line, lineLen = strings.Repeat(" ", column), column
+		} else if i > 0 {
+			line += " "
+			lineLen++
+		}
+		line += word
+		lineLen += wordLen
+	}
+	b.WriteString(line)
+	return b.String()
+}
+
+// helpWidth returns the width of the help, see Parser.HelpWidth.
+func (p *Parser) helpWidth() int {
+	if p.HelpWidth > 0 {
+		return p.HelpWidth
+	}
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+		return columns
+	}
+	return 80
+}
+
+// UsageOnError is what a Parser prints after a usage error, besides the
+// error.
+type UsageOnError int
+
+const (
+	// UsageFull prints the whole usage section. This is the default.
+	UsageFull UsageOnError = iota
+	// UsageClosest prints only the usage line closest to the command-line,
+	// followed by a hint to ask for help, like "See 'prog --help'.".
+	UsageClosest
+	// UsageNone prints nothing else.
+	UsageNone
+)
+
+// shortHelp returns doc up to the end of its last usage or options section.
+func shortHelp(doc string) string {
+	end := 0
+	for _, name := range []string{"usage:", "options:"} {
+		for _, sect := range findSections(name, doc) {
+			end = max(end, sect.offset+len(sect.text))
+		}
+	}
+	if end == 0 {
+		return doc
+	}
+	return doc[:end]
+}
+
+// errorOutput returns what Parse prints for the usage error err, as set by
+// p.UsageOnError.
+func (p *Parser) errorOutput(doc string, argv []string, err error) string {
+	usages := findSections("usage:", doc)
+	if p.UsageOnError == UsageNone || len(usages) != 1 {
+		return err.Error()
+	}
+	options, _ := p.parseDefaults(doc) // valid, as err is a usage error
+	docOptions := slices.Clone(options)
+	tokens, tokErr := tokenListFromUsage(doc, usages[0])
+	if tokErr != nil {
+		return err.Error()
+	}
+	pat, patErr := p.parseTokens(tokens, &options)
+	if patErr != nil {
+		return err.Error()
+	}
+	// One Required per usage line, wrapped in an Either if more than one.
+	lines := pat.children
+	if len(lines) == 1 && lines[0].t&patternEither != 0 {
+		lines = lines[0].children
+	}
+	best, bestScore := 0, -1
+	for i, line := range lines {
+		if score := p.usageScore(line, docOptions, argv); score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	heading, _, _ := stringPartition(usages[0].text, ":")
+	text := usageLines(usages[0].text)
+	output := fmt.Sprintf("%s\n%s: %s", err, heading, text[best])
+	if p.SkipHelpFlags {
+		return output
+	}
+	prog, _, _ := stringPartition(text[0], " ")
+	for _, help := range []string{"--help", "-h"} {
+		if slices.ContainsFunc(options, func(o *pattern) bool { return o.short == help || o.long == help }) {
+			return output + "\n" + p.message(MsgSeeHelp, prog, help)
+		}
+	}
+	return output
+}
+
+// usageScore returns how well the usage line fits argv: two points per
+// command of the line in argv, one per option of the line in argv. The
+// options of the doc are the ones of an [options] shortcut.
+func (p *Parser) usageScore(line *pattern, docOptions patternList, argv []string) int {
+	leaves, _ := line.flat(patternLeaf | patternOptionSSHORTCUT)
+	hasOption := func(name string) bool {
+		for _, l := range leaves {
+			if l.t&patternOptionSSHORTCUT != 0 {
+				if slices.ContainsFunc(docOptions, func(o *pattern) bool { return o.short == name || o.long == name }) {
+					return true
+				}
+			} else if l.t&patternOption != 0 && (l.short == name || l.long == name || l.negated != "" && l.negated == name) {
+				return true
+			}
+		}
+		return false
+	}
+	score := 0
+	for _, arg := range argv {
+		switch {
+		case p.longPrefix(arg) != "":
+			name, _, _ := stringPartition(arg, "=")
+			if hasOption(name) {
+				score++
+			}
+		case p.shortPrefix(arg) != "":
+			if hasOption(arg[:2]) {
+				score++
+			}
+		default:
+			if slices.ContainsFunc(leaves, func(l *pattern) bool { return l.t&patternCommand != 0 && l.name == arg }) {
+				score += 2
+			}
+		}
+	}
+	return score
+}
+
+// Section is a named section of a doc, like "Usage:", "Options:" or
+// "Examples:".
+type Section struct {
+	// Name is the heading before the colon, like "Examples".
+	Name string
+	// Text is the section, heading included, trimmed of white space.
+	Text string
+}
+
+// Sections returns the named sections of doc, in order. A section starts
+// with a heading "Name:" at the start of a line and goes on with the
+// indented lines that follow. The name of a section is the topic to show
+// only it with --help <topic>, or help <topic> if the usage has a command
+// help; the topic is case-insensitive and can have "-" for spaces.
+func Sections(doc string) []Section {
+	var sections []Section
+	for _, text := range parseSection("", doc) {
+		name, _, _ := stringPartition(text, ":")
+		sections = append(sections, Section{Name: strings.TrimSpace(name), Text: text})
+	}
+	return sections
+}
+
+// helpTopic returns the section of doc named topic, if any.
+func helpTopic(doc, topic string) (string, bool) {
+	normalize := func(s string) string {
+		return strings.Join(strings.Fields(strings.ReplaceAll(s, "-", " ")), " ")
+	}
+	for _, sect := range Sections(doc) {
+		if strings.EqualFold(normalize(sect.Name), normalize(topic)) {
+			return sect.Text, true
+		}
+	}
+	return "", false
+}
+
+// Option is an option declared in the options sections of a doc.
+type Option struct {
+	// Short and Long are the names of the option, like "-v" and
+	// "--verbose"; either can be empty.
+	Short string
+	Long  string
+	// ArgCount is the number of arguments of the option: 0 for a flag.
+	ArgCount int
+	// Negated is the --no-name form of a flag declared as --[no-]name, that
+	// sets it to false; empty if none.
+	Negated string
+	// Optional is true if the argument can be omitted, as in
+	// --name[=<arg>]; then the value is Implicit.
+	Optional bool
+	Implicit string
+	// Map is true for an option declared with [map], whose arguments
+	// key=value are collected in a map[string]string.
+	Map bool
+	// Default is the value of the option in Opts when not given.
+	Default any
+	// Description is the description, with white space normalized.
+	Description string
+	// Group is the heading of the options section declaring the option, up
+	// to its first colon, like "Options" or "Network options".
+	Group string
+	// Hidden is true for an option declared with [hidden].
+	Hidden bool
+	// Deprecated is the warning reported when an option declared with
+	// [deprecated] is used, like "--old is deprecated: use --new"; empty if
+	// the option is not deprecated.
+	Deprecated string
+}
+
+// Options returns the options declared in the options sections of doc, in
+// order, for example to generate documentation grouped as the help is. The
+// error, a LanguageError, is for an invalid options section.
+func (p *Parser) Options(doc string) ([]Option, error) {
+	defaults, err := p.parseDefaults(doc)
+	if err != nil {
+		return nil, err
+	}
+	var options []Option
+	for i, desc := range p.optionDescriptions(doc) {
+		o := defaults[i]
+		_, description := splitOptionDescription(desc.text)
+		options = append(options, Option{
+			Short:       o.short,
+			Long:        o.long,
+			ArgCount:    o.argcount,
+			Negated:     o.negated,
+			Optional:    o.optional,
+			Implicit:    o.implicit,
+			Map:         o.isMap,
+			Default:     o.value,
+			Description: description,
+			Group:       desc.group,
+			Hidden:      o.hidden,
+			Deprecated:  o.deprecated,
+		})
+	}
+	return options, nil
+}
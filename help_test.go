@@ -0,0 +1,298 @@
+package docopt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-quicktest/qt"
+)
+
+const helpDoc = `Naval Fate.
+
+Usage:
+  naval_fate ship <name> move <x> <y> [--speed=<kn>] [--moored|--drifting]
+  naval_fate -h | --help
+
+Options:
+  -h --help     Show this screen.
+  --speed=<kn>  Speed in knots, a long description that does not fit in the width [default: 10].
+  --moored      Moored (anchored)
+                mine.
+  --drifting  Drifting mine.
+  --secret      Secret [hidden]
+
+Other options:
+  --a-very-long-option-name=<value>  Too long to align.`
+
+func TestHelpRaw(t *testing.T) {
+	parser := &Parser{}
+
+	help, err := parser.Help(helpDoc)
+
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(help, strings.Replace(helpDoc, "\n  --secret      Secret [hidden]", "", 1)))
+}
+
+func TestHelpFormat(t *testing.T) {
+	parser := &Parser{FormatHelp: true, HelpWidth: 60}
+
+	help, err := parser.Help(helpDoc)
+
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(help, `Naval Fate.
+
+Usage:
+  naval_fate ship <name> move <x> <y> [--speed=<kn>] [--moored|--drifting]
+  naval_fate -h | --help
+
+Options:
+  -h --help                   Show this screen.
+  --speed=<kn>                Speed in knots, a long
+                              description that does not fit
+                              in the width [default: 10].
+  --moored                    Moored (anchored) mine.
+  --drifting                  Drifting mine.
+
+Other options:
+  --a-very-long-option-name=<value>
+                              Too long to align.`))
+}
+
+func TestHelpWidth(t *testing.T) {
+	t.Setenv("COLUMNS", "100")
+	qt.Assert(t, qt.Equals((&Parser{}).helpWidth(), 100))
+	qt.Assert(t, qt.Equals((&Parser{HelpWidth: 40}).helpWidth(), 40))
+
+	t.Setenv("COLUMNS", "")
+	qt.Assert(t, qt.Equals((&Parser{}).helpWidth(), 80))
+}
+
+func TestParseFormatHelp(t *testing.T) {
+	var stdout strings.Builder
+	parser := &Parser{FormatHelp: true, HelpWidth: 80, Stdout: &stdout}
+
+	_, err := parser.Parse("Usage: prog [options]\n\nOptions:\n  -h, --help  Help.\n  -v    Verbose.", []string{"-h"}, "")
+
+	qt.Assert(t, qt.ErrorIs(err, ErrHelp))
+	qt.Assert(t, qt.Equals(stdout.String(), "Usage: prog [options]\n\nOptions:\n  -h, --help  Help.\n  -v          Verbose.\n"))
+}
+
+func TestUsageOnError(t *testing.T) {
+	doc := `Naval Fate.
+
+Usage:
+  naval_fate ship new <name>...
+  naval_fate ship <name> move <x> <y> [--speed=<kn>]
+  naval_fate mine (set|remove) <x> <y> [--moored|--drifting]
+  naval_fate -h | --help
+
+Options:
+  -h --help     Show this screen.
+  --speed=<kn>  Speed in knots [default: 10].
+  --moored      Moored (anchored) mine.
+  --drifting    Drifting mine.`
+	testCases := []struct {
+		policy UsageOnError
+		argv   []string
+		want   string
+	}{
+		{UsageClosest, []string{"mine", "set", "1"},
+			"Usage: naval_fate mine (set|remove) <x> <y> [--moored|--drifting]\n" +
+				"See 'naval_fate --help'.\n"},
+		{UsageClosest, []string{"ship", "x", "move", "--speed=3"},
+			"Usage: naval_fate ship <name> move <x> <y> [--speed=<kn>]\n" +
+				"See 'naval_fate --help'.\n"},
+		{UsageClosest, []string{"--moored"},
+			"Usage: naval_fate mine (set|remove) <x> <y> [--moored|--drifting]\n" +
+				"See 'naval_fate --help'.\n"},
+		// Nothing fits: the first line.
+		{UsageClosest, []string{"fly"},
+			"Usage: naval_fate ship new <name>...\n" +
+				"See 'naval_fate --help'.\n"},
+		{UsageNone, []string{"fly"}, ""},
+	}
+	for _, tc := range testCases {
+		var stderr strings.Builder
+		parser := &Parser{UsageOnError: tc.policy, Stderr: &stderr}
+		_, err := parser.Parse(doc, tc.argv, "")
+		qt.Assert(t, qt.ErrorAs(err, new(*UserError)))
+		qt.Assert(t, qt.Equals(stderr.String(), err.Error()+"\n"+tc.want), qt.Commentf("argv: %q", tc.argv))
+	}
+
+	var stderr strings.Builder
+	parser := &Parser{UsageOnError: UsageClosest, SkipHelpFlags: true, Stderr: &stderr}
+	_, err := parser.Parse("Usage: prog go", []string{"stop"}, "")
+	qt.Assert(t, qt.ErrorAs(err, new(*UserError)))
+	qt.Assert(t, qt.Equals(stderr.String(), err.Error()+"\nUsage: prog go\n"))
+}
+
+func TestHelpAll(t *testing.T) {
+	doc := `Usage: prog [options]
+
+Options:
+  -h --help   Show the options.
+  --help-all  Show all the help.
+
+Environment:
+  PROG_HOME   Where to look.
+`
+	var stdout strings.Builder
+	parser := &Parser{Stdout: &stdout}
+
+	_, err := parser.Parse(doc, []string{"--help"}, "")
+	qt.Assert(t, qt.ErrorIs(err, ErrHelp))
+	qt.Assert(t, qt.Equals(stdout.String(), `Usage: prog [options]
+
+Options:
+  -h --help   Show the options.
+  --help-all  Show all the help.
+`))
+
+	stdout.Reset()
+	_, err = parser.Parse(doc, []string{"--help-all"}, "")
+	qt.Assert(t, qt.ErrorIs(err, ErrHelp))
+	qt.Assert(t, qt.Equals(stdout.String(), doc))
+
+	// Not declared: an unknown option.
+	stdout.Reset()
+	var stderr strings.Builder
+	parser.Stderr = &stderr
+	_, err = parser.Parse("Usage: prog [options]\n\nOptions:\n  -h --help  Show help.", []string{"--help-all"}, "")
+	qt.Assert(t, qt.ErrorAs(err, new(*UserError)))
+	qt.Assert(t, qt.ErrorMatches(err, "unknown option: --help-all"))
+	qt.Assert(t, qt.Equals(stdout.String(), ""))
+}
+
+func TestHelpTopics(t *testing.T) {
+	doc := `Prog.
+
+Usage:
+  prog [options] <file>
+  prog help [<topic>]
+
+Options:
+  -h --help  Show help.
+
+Network options:
+  --proxy=<url>  Proxy.
+
+Examples:
+  prog --proxy=http://proxy a.txt
+`
+	qt.Assert(t, qt.DeepEquals(Sections(doc), []Section{
+		{"Usage", "Usage:\n  prog [options] <file>\n  prog help [<topic>]"},
+		{"Options", "Options:\n  -h --help  Show help."},
+		{"Network options", "Network options:\n  --proxy=<url>  Proxy."},
+		{"Examples", "Examples:\n  prog --proxy=http://proxy a.txt"},
+	}))
+
+	testCases := []struct {
+		argv []string
+		want string
+	}{
+		{[]string{"--help", "examples"}, "Examples:\n  prog --proxy=http://proxy a.txt\n"},
+		{[]string{"-h", "Network-Options"}, "Network options:\n  --proxy=<url>  Proxy.\n"},
+		{[]string{"help", "usage"}, "Usage:\n  prog [options] <file>\n  prog help [<topic>]\n"},
+		{[]string{"help"}, doc},
+	}
+	for _, tc := range testCases {
+		var stdout strings.Builder
+		parser := &Parser{Stdout: &stdout}
+		_, err := parser.Parse(doc, tc.argv, "")
+		qt.Assert(t, qt.ErrorIs(err, ErrHelp), qt.Commentf("argv: %q", tc.argv))
+		qt.Assert(t, qt.Equals(stdout.String(), tc.want), qt.Commentf("argv: %q", tc.argv))
+	}
+
+	// Not a section: left to the usage.
+	v, err := testParser.Parse(doc, []string{"help", "colors"}, "")
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(v, Opts{"--help": false, "--proxy": nil, "<file>": nil, "help": true, "<topic>": "colors"}))
+
+	// Not a section: an argument, and the full help as usual.
+	var stdout strings.Builder
+	parser := &Parser{Stdout: &stdout}
+	_, err = parser.Parse("usage: prog [-h] <file>", []string{"-h", "x"}, "")
+	qt.Assert(t, qt.ErrorIs(err, ErrHelp))
+	qt.Assert(t, qt.Equals(stdout.String(), "usage: prog [-h] <file>\n"))
+
+	// A command help of the program.
+	v, err = parser.Parse("Usage:\n  prog help <cmd>\n  prog run", []string{"help", "run"}, "")
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(v, Opts{"<cmd>": "run", "help": true, "run": false}))
+
+	// Without a command help, "help" is just an argument.
+	v, err = parser.Parse("Usage: prog <file>", []string{"help"}, "")
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(v, Opts{"<file>": "help"}))
+}
+
+func TestOptionSyntax(t *testing.T) {
+	doc := `Usage: prog [options]
+
+Options:
+  --[no-]color            Colorize.
+  --color-mode[=<when>]   When to colorize [implicit: always].
+  -D <key=value>          Define [map].
+  --old                   Old [deprecated: use --new].`
+
+	options, err := testParser.Options(doc)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(options, []Option{
+		{Long: "--color", Negated: "--no-color", Default: false, Description: "Colorize.", Group: "Options"},
+		{Long: "--color-mode", ArgCount: 1, Optional: true, Implicit: "always", Default: nil, Description: "When to colorize [implicit: always].", Group: "Options"},
+		{Short: "-D", ArgCount: 1, Map: true, Default: nil, Description: "Define [map].", Group: "Options"},
+		{Long: "--old", Default: false, Description: "Old [deprecated: use --new].", Group: "Options", Deprecated: "--old is deprecated: use --new"},
+	}))
+}
+
+func TestInvalidDocErrors(t *testing.T) {
+	doc := "Usage: prog [options]\n\nOptions:\n  --[no-]color  Colorize [default: yes]."
+	_, err := testParser.Help(doc)
+	qt.Assert(t, qt.ErrorAs(err, new(*LanguageError)))
+	_, err = testParser.Options(doc)
+	qt.Assert(t, qt.ErrorAs(err, new(*LanguageError)))
+	_, err = testParser.Commands(doc)
+	qt.Assert(t, qt.ErrorAs(err, new(*LanguageError)))
+	_, err = testParser.Commands("Usage: prog (run")
+	qt.Assert(t, qt.ErrorAs(err, new(*LanguageError)))
+}
+
+func TestOptionGroups(t *testing.T) {
+	doc := `Usage: prog [options]
+
+Options:
+  -h --help        Show help.
+
+Network options:
+  --proxy=<url>    Proxy [default: none].
+  --offline        Do not connect [hidden]
+
+bla: options: --foo  Foo.`
+
+	options, err := testParser.Options(doc)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(options, []Option{
+		{Short: "-h", Long: "--help", Default: false, Description: "Show help.", Group: "Options"},
+		{Long: "--proxy", ArgCount: 1, Default: "none", Description: "Proxy [default: none].", Group: "Network options"},
+		{Long: "--offline", Default: false, Description: "Do not connect [hidden]", Group: "Network options", Hidden: true},
+		{Long: "--foo", Default: false, Description: "Foo.", Group: "bla"},
+	}))
+
+	v, err := testParser.Parse(doc, []string{"--foo", "--proxy=p"}, "")
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(v, Opts{"--help": false, "--proxy": "p", "--offline": false, "--foo": true}))
+
+	// The description after a heading is left as is.
+	parser := &Parser{FormatHelp: true, HelpWidth: 60}
+	help, err := parser.Help(doc)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(help, `Usage: prog [options]
+
+Options:
+  -h --help      Show help.
+
+Network options:
+  --proxy=<url>  Proxy [default: none].
+
+bla: options: --foo  Foo.`))
+}
@@ -0,0 +1,105 @@
+package docopt
+
+import "fmt"
+
+// Message is a message that a Parser prints or returns to the user, like
+// the text of a UserError. Its arguments are documented with each message.
+type Message int
+
+const (
+	// MsgUnknownOption has the option, as given.
+	MsgUnknownOption Message = iota
+	// MsgUnknownArgument has the name of the argument (empty for the
+	// command-line) and its value.
+	MsgUnknownArgument
+	// MsgUnknownCommand has the command, as given, and the suggested one.
+	MsgUnknownCommand
+	// MsgRequiresArgument has the option.
+	MsgRequiresArgument
+	// MsgRequiresArguments has the option and the number of arguments.
+	MsgRequiresArguments
+	// MsgRequiresKeyValue has the map option and the argument, as given.
+	MsgRequiresKeyValue
+	// MsgNotUniquePrefix has the abbreviated option and the options it is a
+	// prefix of, separated by ", ".
+	MsgNotUniquePrefix
+	// MsgMustNotHaveArgument has the option.
+	MsgMustNotHaveArgument
+	// MsgSpecifiedAmbiguously has the option and the number of times.
+	MsgSpecifiedAmbiguously
+	// MsgMustNotBeFollowedByEqual has the short option and the argument, as
+	// given (-o=value).
+	MsgMustNotBeFollowedByEqual
+	// MsgAbbreviation has the abbreviated option and the option.
+	MsgAbbreviation
+	// MsgDeprecated has the option.
+	MsgDeprecated
+	// MsgDeprecatedNote has the option and the note of [deprecated: note].
+	MsgDeprecatedNote
+	// MsgWarning has a warning, as printed when Parser.Warn is nil.
+	MsgWarning
+	// MsgSeeHelp has the program and the help option.
+	MsgSeeHelp
+	// MsgResponseFile has the response file and the error reading it.
+	MsgResponseFile
+	// MsgResponseFileLoop has the response file.
+	MsgResponseFileLoop
+	// MsgMissingQuote has the quote character of a response file.
+	MsgMissingQuote
+	// MsgBackslashAtEnd has no arguments.
+	MsgBackslashAtEnd
+)
+
+// Messages is a catalog of the messages of a Parser, to translate them.
+type Messages interface {
+	// Format returns the fmt format of m, taking the arguments documented
+	// for m. The format can use explicit argument indexes, like %[2]s, to
+	// change their order.
+	Format(m Message) string
+}
+
+// English is the default catalog, in English.
+var English Messages = MessageMap{}
+
+// MessageMap is a catalog mapping messages to their format. The messages not
+// in the map are in English.
+type MessageMap map[Message]string
+
+// Format returns the format of m.
+func (mm MessageMap) Format(m Message) string {
+	if format, ok := mm[m]; ok {
+		return format
+	}
+	return english[m]
+}
+
+var english = map[Message]string{
+	MsgUnknownOption:            "unknown option: %s",
+	MsgUnknownArgument:          "unknown argument: %s %v",
+	MsgUnknownCommand:           "unknown command: %s, did you mean %s?",
+	MsgRequiresArgument:         "%s requires argument",
+	MsgRequiresArguments:        "%s requires %d arguments",
+	MsgRequiresKeyValue:         "%s requires an argument key=value, got: %s",
+	MsgNotUniquePrefix:          "%s is not a unique prefix: %s?",
+	MsgMustNotHaveArgument:      "%s must not have an argument",
+	MsgSpecifiedAmbiguously:     "%s is specified ambiguously %d times",
+	MsgMustNotBeFollowedByEqual: "%s must not be followed by '=': %s",
+	MsgAbbreviation:             "%s is an abbreviation of %s",
+	MsgDeprecated:               "%s is deprecated",
+	MsgDeprecatedNote:           "%s is deprecated: %s",
+	MsgWarning:                  "warning: %s",
+	MsgSeeHelp:                  "See '%s %s'.",
+	MsgResponseFile:             "response file %s: %s",
+	MsgResponseFileLoop:         "response file %s: includes itself",
+	MsgMissingQuote:             "missing closing quote %c",
+	MsgBackslashAtEnd:           "backslash at end of file",
+}
+
+// message returns the message m, translated by p.Messages.
+func (p *Parser) message(m Message, a ...any) string {
+	messages := p.Messages
+	if messages == nil {
+		messages = English
+	}
+	return fmt.Sprintf(messages.Format(m), a...)
+}
@@ -0,0 +1,55 @@
+package docopt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-quicktest/qt"
+)
+
+func TestEnglishMessages(t *testing.T) {
+	for m := MsgUnknownOption; m <= MsgBackslashAtEnd; m++ {
+		qt.Assert(t, qt.Not(qt.Equals(English.Format(m), "")), qt.Commentf("message %d", m))
+	}
+}
+
+func TestTranslatedMessages(t *testing.T) {
+	doc := `Usage: prog [options]
+
+Options:
+  --verbose  Verbose.
+  --version  Version.
+  --old      Old [deprecated]
+  -o <file>  Output.`
+	var stderr strings.Builder
+	parser := &Parser{
+		Messages: MessageMap{
+			MsgUnknownOption:    "option inconnue : %s",
+			MsgRequiresArgument: "il faut un argument à %s",
+			MsgNotUniquePrefix:  "%[2]s ? (%[1]s est ambigu)",
+			MsgDeprecated:       "%s est obsolète",
+			MsgWarning:          "attention : %s",
+		},
+		Stderr: &stderr,
+	}
+	testCases := []struct {
+		argv []string
+		want string
+	}{
+		{[]string{"--foo"}, "option inconnue : --foo"},
+		{[]string{"-o"}, "il faut un argument à -o"},
+		{[]string{"--ver"}, "--verbose, --version ? (--ver est ambigu)"},
+		// Not translated: in English.
+		{[]string{"--verbose=x"}, "--verbose must not have an argument"},
+	}
+	for _, tc := range testCases {
+		_, err := parser.Parse(doc, tc.argv, "")
+		qt.Assert(t, qt.ErrorAs(err, new(*UserError)), qt.Commentf("argv: %q", tc.argv))
+		qt.Assert(t, qt.Equals(err.Error(), tc.want), qt.Commentf("argv: %q", tc.argv))
+	}
+
+	stderr.Reset()
+	_, err := parser.Parse(doc, []string{"--old"}, "")
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(stderr.String(), "attention : --old est obsolète\n"))
+}
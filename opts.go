@@ -91,6 +91,20 @@ func (o Opts) Float64(key string) (f float64, err error) {
 	return
 }
 
+// Map returns the value of a map option, declared with [map].
+func (o Opts) Map(key string) (m map[string]string, err error) {
+	v, ok := o[key]
+	if !ok {
+		err = errKey(key)
+		return
+	}
+	m, ok = v.(map[string]string)
+	if !ok {
+		err = errType(key)
+	}
+	return
+}
+
 // Bind populates the fields of a given struct with matching option values.
 // Each key in Opts will be mapped to an exported field of the struct pointed
 // to by `v`, as follows:
@@ -109,7 +123,9 @@ func (o Opts) Float64(key string) (f float64, err error) {
 // with an option's key, Bind will try to map the option to an appropriately
 // named field (as above).
 //
-// Bind also handles conversion to bool, float, int or string types.
+// Bind also handles conversion to bool, float, int or string types, and
+// converts the values of a map option to the values of a map field, for
+// example map[string]int.
 func (o Opts) Bind(v any) error {
 	structVal := reflect.ValueOf(v)
 	if structVal.Kind() != reflect.Ptr {
@@ -211,6 +227,13 @@ func (o Opts) Bind(v any) error {
 				field.SetFloat(x)
 				continue
 			}
+		case reflect.Map:
+			if m, err := o.Map(k); err == nil {
+				if x, ok := convertMap(m, field.Type()); ok {
+					field.Set(x)
+					continue
+				}
+			}
 		}
 		// TODO: Something clever (recursive?) with non-string slices.
 		// case reflect.Slice:
@@ -227,6 +250,52 @@ func (o Opts) Bind(v any) error {
 	return nil
 }
 
+// convertMap converts m to a map of type t, with keys of kind string and
+// values of kind bool, float, int or string.
+func convertMap(m map[string]string, t reflect.Type) (reflect.Value, bool) {
+	if t.Key().Kind() != reflect.String {
+		return reflect.Value{}, false
+	}
+	x := reflect.MakeMapWithSize(t, len(m))
+	for k, v := range m {
+		elem := reflect.New(t.Elem()).Elem()
+		if !setString(elem, v) {
+			return reflect.Value{}, false
+		}
+		x.SetMapIndex(reflect.ValueOf(k).Convert(t.Key()), elem)
+	}
+	return x, true
+}
+
+// setString sets v, of kind bool, float, int or string, to the value of s.
+func setString(v reflect.Value, s string) bool {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return false
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return false
+		}
+		v.SetInt(i)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return false
+		}
+		v.SetFloat(f)
+	default:
+		return false
+	}
+	return true
+}
+
 // isUnexportedField returns whether the field is unexported.
 // isUnexportedField is to avoid the bug in versions older than Go1.3.
 // See following links:
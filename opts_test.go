@@ -334,3 +334,39 @@ func TestBindHyphenatedTags(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestBindMap(t *testing.T) {
+	parser := &Parser{SkipHelpFlags: true}
+	doc := "Usage: prog [-D <key=value>]... [--limit <key=n>]...\nOptions:\n -D <key=value>  [map]\n --limit <key=n>  [map]"
+	opts, err := parser.Parse(doc, []string{"-D", "a=1", "-Db=x=y", "--limit", "cpu=2"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := opts.Map("-D")
+	if err != nil || !reflect.DeepEqual(m, map[string]string{"a": "1", "b": "x=y"}) {
+		t.Fatal(m, err)
+	}
+	var opt struct {
+		D     map[string]string
+		Limit map[string]int
+	}
+	if err := opts.Bind(&opt); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(opt.D, map[string]string{"a": "1", "b": "x=y"}) ||
+		!reflect.DeepEqual(opt.Limit, map[string]int{"cpu": 2}) {
+		t.Fatalf("%#v", opt)
+	}
+
+	opts, err = parser.Parse(doc, []string{"--limit", "cpu=many"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var optInt struct {
+		D     map[string]string
+		Limit map[string]int
+	}
+	if err := opts.Bind(&optInt); err == nil {
+		t.Fatal("error expected")
+	}
+}
@@ -74,6 +74,26 @@ type pattern struct {
 	short    string
 	long     string
 	argcount int
+	// negated is the --no-name form of a flag declared as --[no-]name, that
+	// the command-line can give to set it to false; empty if none.
+	negated string
+	// optional is true for an option whose argument can be omitted
+	// (--name[=<arg>]); then its value is implicit.
+	optional bool
+	implicit string
+	// isMap is true for an option declared with [map], whose arguments
+	// key=value are collected in a map[string]string.
+	isMap bool
+	// hidden is true for an option declared with [hidden], not shown in the
+	// help.
+	hidden bool
+	// deprecated is the warning for an option declared with [deprecated],
+	// reported when used.
+	deprecated string
+
+	// argvIndex is the index in argv of the element a pattern of argv was
+	// parsed from, when known.
+	argvIndex int
 }
 
 type patternList []*pattern
@@ -151,6 +171,12 @@ func newOption(short, long string, argcount int, value any) *pattern {
 	return &p
 }
 
+// copyLeaf returns a copy of the leaf p.
+func (p *pattern) copyLeaf() *pattern {
+	c := *p
+	return &c
+}
+
 func (p *pattern) flat(types patternType) (patternList, error) {
 	if p.t&patternLeaf != 0 {
 		if types == patternDefault {
@@ -219,67 +245,161 @@ func (p *pattern) fixIdentities(uniq patternList) error {
 
 func (p *pattern) fixRepeatingArguments() {
 	// Fix elements that should accumulate/increment values.
-	var either []patternList
+	//
+	// An element accumulates if it can appear more than once in a single
+	// branch of the pattern, that is in one of the cases of p.transform().
+	// Instead of expanding the pattern, which grows exponentially with the
+	// number of Either, count the maximum number of times each element can
+	// appear in a single branch.
+	var uniq patternList
+	ids := make(map[*pattern]int)
+	members := make(map[int]patternList)
+	p.walkLeaves(func(leaf *pattern) {
+		if _, ok := ids[leaf]; ok {
+			return
+		}
+		id := -1
+		for i, u := range uniq {
+			if u.eq(leaf) {
+				id = i
+				break
+			}
+		}
+		if id == -1 {
+			id = len(uniq)
+			uniq = append(uniq, leaf)
+		}
+		ids[leaf] = id
+		members[id] = append(members[id], leaf)
+	})
+
+	counts := p.maxCounts(ids, len(uniq))
+	for id, count := range counts {
+		if count < 2 {
+			continue
+		}
+		for _, e := range members[id] {
+			e.setRepeating()
+		}
+	}
+}
 
-	for _, child := range p.transform().children {
-		either = append(either, child.children)
+// setRepeating sets the value of the leaf p, that can appear more than once,
+// to accumulate or count.
+func (p *pattern) setRepeating() {
+	if p.t == patternOption && p.argcount > 1 {
+		switch v := p.value.(type) {
+		case []string:
+			p.value = [][]string{v}
+		case [][]string:
+		default:
+			p.value = [][]string{}
+		}
+		return
 	}
-	for _, cas := range either {
-		casMultiple := patternList{}
-		for _, e := range cas {
-			if cas.count(e) > 1 {
-				casMultiple = append(casMultiple, e)
-			}
+	if p.t == patternArgument || p.t == patternOption && p.argcount > 0 {
+		switch v := p.value.(type) {
+		case string:
+			p.value = strings.Fields(v)
+		case []string:
+		default:
+			p.value = []string{}
 		}
-		for _, e := range casMultiple {
-			if e.t == patternArgument || e.t == patternOption && e.argcount > 0 {
-				switch v := e.value.(type) {
-				case string:
-					e.value = strings.Fields(v)
-				case []string:
-				default:
-					e.value = []string{}
-				}
-			}
-			if e.t == patternCommand || e.t == patternOption && e.argcount == 0 {
-				e.value = 0
+	}
+	if p.t == patternCommand || p.t == patternOption && p.argcount == 0 {
+		p.value = 0
+	}
+}
+
+// walkLeaves calls fn for each leaf of the pattern tree, in order.
+func (p *pattern) walkLeaves(fn func(*pattern)) {
+	if p.t&patternLeaf != 0 {
+		fn(p)
+		return
+	}
+	for _, child := range p.children {
+		child.walkLeaves(fn)
+	}
+}
+
+// maxCounts returns, for each leaf id, how many times the leaf can appear in
+// a single branch of p, capped at 2 since we only care about repetitions.
+// The counts add up along a sequence, an Either takes the maximum over its
+// alternatives and a OneOrMore doubles them (the same as p.transform()).
+func (p *pattern) maxCounts(ids map[*pattern]int, n int) []int {
+	counts := make([]int, n)
+	if p.t&patternLeaf != 0 {
+		counts[ids[p]] = 1
+		return counts
+	}
+	for _, child := range p.children {
+		childCounts := child.maxCounts(ids, n)
+		for i, c := range childCounts {
+			if p.t&patternEither != 0 {
+				counts[i] = max(counts[i], c)
+			} else {
+				counts[i] = min(counts[i]+c, 2)
 			}
 		}
 	}
+	if p.t&patternOneOrMore != 0 {
+		for i, c := range counts {
+			counts[i] = min(2*c, 2)
+		}
+	}
+	return counts
 }
 
 func (p *pattern) match(left *patternList, collected *patternList) (bool, *patternList, *patternList) {
+	matched, l, c, _ := p.matchTrail(left, collected, nil)
+	return matched, l, c
+}
+
+// trail records the leaves matched so far, most recent first. Being
+// persistent, it is cheap to extend and to drop while backtracking.
+type trail struct {
+	prev *trail
+	leaf *pattern // leaf of the pattern
+	arg  *pattern // element of argv it matched
+}
+
+// matchTrail is like match, but also returns tr extended with the leaves
+// matched.
+func (p *pattern) matchTrail(left *patternList, collected *patternList, tr *trail,
+) (bool, *patternList, *patternList, *trail) {
 	if collected == nil {
 		collected = &patternList{}
 	}
 	if p.t&patternRequired != 0 {
 		l := left
 		c := collected
+		t := tr
 		for _, p := range p.children {
 			var matched bool
-			matched, l, c = p.match(l, c)
+			matched, l, c, t = p.matchTrail(l, c, t)
 			if !matched {
-				return false, left, collected
+				return false, left, collected, tr
 			}
 		}
-		return true, l, c
+		return true, l, c, t
 	} else if p.t&patternOptionAL != 0 || p.t&patternOptionSSHORTCUT != 0 {
 		for _, p := range p.children {
-			_, left, collected = p.match(left, collected)
+			_, left, collected, tr = p.matchTrail(left, collected, tr)
 		}
-		return true, left, collected
+		return true, left, collected, tr
 	} else if p.t&patternOneOrMore != 0 {
 		if len(p.children) != 1 {
 			panic("OneOrMore.match(): assert len(p.children) == 1")
 		}
 		l := left
 		c := collected
+		t := tr
 		var lAlt *patternList
 		matched := true
 		times := 0
 		for matched {
 			// could it be that something didn't match but changed l or c?
-			matched, l, c = p.children[0].match(l, c)
+			matched, l, c, t = p.children[0].matchTrail(l, c, t)
 			if matched {
 				times++
 			}
@@ -289,53 +409,42 @@ func (p *pattern) match(left *patternList, collected *patternList) (bool, *patte
 			lAlt = l
 		}
 		if times >= 1 {
-			return true, l, c
+			return true, l, c, t
 		}
-		return false, left, collected
+		return false, left, collected, tr
 	} else if p.t&patternEither != 0 {
-		type outcomeStruct struct {
-			matched   bool
-			left      *patternList
-			collected *patternList
-			length    int
-		}
-		outcomes := []outcomeStruct{}
-		for _, p := range p.children {
-			matched, l, c := p.match(left, collected)
-			outcome := outcomeStruct{matched, l, c, len(*l)}
-			if matched {
-				outcomes = append(outcomes, outcome)
-			}
-		}
-		if len(outcomes) > 0 {
-			minLen := outcomes[0].length
-			minIndex := 0
-			for i, v := range outcomes {
-				if v.length < minLen {
-					minIndex = i
-				}
-			}
-			return outcomes[minIndex].matched, outcomes[minIndex].left, outcomes[minIndex].collected
-		}
-		return false, left, collected
+		_, matched, l, c, t := p.matchEither(left, collected, tr)
+		return matched, l, c, t
 	} else if p.t&patternLeaf != 0 {
 		pos, match := p.singleMatch(left)
 		var increment any
 		if match == nil {
-			return false, left, collected
+			return false, left, collected, tr
 		}
-		leftAlt := make(patternList, len((*left)[:pos]), len((*left)[:pos])+len((*left)[pos+1:]))
-		copy(leftAlt, (*left)[:pos])
+		tr = &trail{prev: tr, leaf: p, arg: (*left)[pos]}
+		leftAlt := make(patternList, 0, len(*left)-1)
+		leftAlt = append(leftAlt, (*left)[:pos]...)
 		leftAlt = append(leftAlt, (*left)[pos+1:]...)
-		sameName := patternList{}
+		var sameName *pattern
 		for _, a := range *collected {
 			if a.name == p.name {
-				sameName = append(sameName, a)
+				sameName = a
+				break
 			}
 		}
 
+		if _, counted := p.value.(int); counted && match.value == false {
+			// A negated flag, --no-name, resets the count.
+			if sameName == nil {
+				match.value = 0
+				collectedMatch := collected.with(match)
+				return true, &leftAlt, &collectedMatch, tr
+			}
+			sameName.value = 0
+			return true, &leftAlt, collected, tr
+		}
 		switch p.value.(type) {
-		case int, []string:
+		case int, []string, [][]string:
 			switch p.value.(type) {
 			case int:
 				increment = 1
@@ -346,30 +455,83 @@ func (p *pattern) match(left *patternList, collected *patternList) (bool, *patte
 				default:
 					increment = match.value
 				}
+			case [][]string:
+				increment = [][]string{match.value.([]string)}
 			}
-			if len(sameName) == 0 {
+			if sameName == nil {
 				match.value = increment
-				collectedMatch := make(patternList, len(*collected), len(*collected)+1)
-				copy(collectedMatch, *collected)
-				collectedMatch = append(collectedMatch, match)
-				return true, &leftAlt, &collectedMatch
+				collectedMatch := collected.with(match)
+				return true, &leftAlt, &collectedMatch, tr
 			}
-			switch sameName[0].value.(type) {
+			switch sameName.value.(type) {
 			case int:
-				sameName[0].value = sameName[0].value.(int) + increment.(int)
+				sameName.value = sameName.value.(int) + increment.(int)
 			case []string:
-				sameName[0].value = append(sameName[0].value.([]string), increment.([]string)...)
+				sameName.value = append(sameName.value.([]string), increment.([]string)...)
+			case [][]string:
+				sameName.value = append(sameName.value.([][]string), increment.([][]string)...)
 			}
-			return true, &leftAlt, collected
+			return true, &leftAlt, collected, tr
 		}
-		collectedMatch := make(patternList, len(*collected), len(*collected)+1)
-		copy(collectedMatch, *collected)
-		collectedMatch = append(collectedMatch, match)
-		return true, &leftAlt, &collectedMatch
+		collectedMatch := collected.with(match)
+		return true, &leftAlt, &collectedMatch, tr
 	}
 	panic("unmatched type")
 }
 
+// matchEither matches the alternatives of an Either and returns also the
+// index of the one chosen. Among the alternatives that match, the one leaving
+// the fewest elements of left wins; on a tie, the first one wins.
+func (p *pattern) matchEither(left *patternList, collected *patternList, tr *trail,
+) (int, bool, *patternList, *patternList, *trail) {
+	best := -1
+	bestLeft, bestCollected, bestTrail := left, collected, tr
+	for i, child := range p.children {
+		matched, l, c, t := child.matchTrail(left, collected, tr)
+		if matched && (best == -1 || len(*l) < len(*bestLeft)) {
+			best, bestLeft, bestCollected, bestTrail = i, l, c, t
+		}
+	}
+	if best == -1 {
+		return -1, false, left, collected, tr
+	}
+	return best, true, bestLeft, bestCollected, bestTrail
+}
+
+// matchUsage matches left against a pattern returned by parsePattern for a
+// formal usage, returning also the index of the usage line that matched,
+// chosen as in matchEither.
+func (p *pattern) matchUsage(left *patternList) (int, bool, *patternList, *patternList, *trail) {
+	// One Required per usage line, wrapped in an Either if more than one.
+	if len(p.children) == 1 && p.children[0].t&patternEither != 0 {
+		return p.children[0].matchEither(left, nil, nil)
+	}
+	matched, l, c, t := p.matchTrail(left, nil, nil)
+	return 0, matched, l, c, t
+}
+
+// usageLine returns the usage line i of a pattern returned by parsePattern
+// for a formal usage, as numbered by matchUsage.
+func (p *pattern) usageLine(i int) *pattern {
+	if len(p.children) == 1 && p.children[0].t&patternEither != 0 {
+		return p.children[0].children[i]
+	}
+	return p
+}
+
+// hasLeaf reports whether p has a leaf of type t named name.
+func (p *pattern) hasLeaf(t patternType, name string) bool {
+	if p.t&patternLeaf != 0 {
+		return p.t&t != 0 && p.name == name
+	}
+	for _, child := range p.children {
+		if child.hasLeaf(t, name) {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *pattern) singleMatch(left *patternList) (int, *pattern) {
 	if p.t&patternArgument != 0 {
 		for n, pat := range *left {
@@ -417,63 +579,23 @@ func (p *pattern) String() string {
 	panic("unmatched type")
 }
 
-func (p *pattern) transform() *pattern {
-	/*
-		Expand pattern into an (almost) equivalent one, but with single Either.
-
-		Example: ((-a | -b) (-c | -d)) => (-a -c | -a -d | -b -c | -b -d)
-		Quirks: [-a] => (-a), (-a...) => (-a -a)
-	*/
-	result := []patternList{}
-	groups := []patternList{{p}}
-	parents := patternRequired +
-		patternOptionAL +
-		patternOptionSSHORTCUT +
-		patternEither +
-		patternOneOrMore
-	for len(groups) > 0 {
-		children := groups[0]
-		groups = groups[1:]
-		var child *pattern
-		for _, c := range children {
-			if c.t&parents != 0 {
-				child = c
-				break
-			}
-		}
-		if child != nil {
-			children.remove(child)
-			if child.t&patternEither != 0 {
-				for _, c := range child.children {
-					r := patternList{}
-					r = append(r, c)
-					r = append(r, children...)
-					groups = append(groups, r)
-				}
-			} else if child.t&patternOneOrMore != 0 {
-				r := patternList{}
-				r = append(r, child.children.double()...)
-				r = append(r, children...)
-				groups = append(groups, r)
-			} else {
-				r := patternList{}
-				r = append(r, child.children...)
-				r = append(r, children...)
-				groups = append(groups, r)
-			}
-		} else {
-			result = append(result, children)
-		}
+func (p *pattern) eq(other *pattern) bool {
+	if p == other {
+		return true
 	}
-	either := patternList{}
-	for _, e := range result {
-		either = append(either, newRequired(e...))
+	// Cheap checks first: most comparisons are between different leaves.
+	if p == nil || other == nil || p.t != other.t || p.name != other.name ||
+		len(p.children) != len(other.children) {
+		return false
 	}
-	return newEither(either...)
+	return reflect.DeepEqual(p, other)
 }
 
-func (p *pattern) eq(other *pattern) bool {
-	return reflect.DeepEqual(p, other)
+// with returns a copy of pl with p appended.
+func (pl *patternList) with(p *pattern) patternList {
+	result := make(patternList, len(*pl), len(*pl)+1)
+	copy(result, *pl)
+	return append(result, p)
 }
 
 func (pl patternList) unique() patternList {
@@ -497,16 +619,6 @@ func (pl patternList) index(p *pattern) (int, error) {
 	return -1, fmt.Errorf("%s not in list", p)
 }
 
-func (pl patternList) count(p *pattern) int {
-	count := 0
-	for _, c := range pl {
-		if c.eq(p) {
-			count++
-		}
-	}
-	return count
-}
-
 func (pl patternList) diff(l patternList) patternList {
 	lAlt := make(patternList, len(l))
 	copy(lAlt, l)
@@ -529,18 +641,6 @@ func (pl patternList) diff(l patternList) patternList {
 	return result
 }
 
-func (pl patternList) double() patternList {
-	l := len(pl)
-	result := make(patternList, l*2)
-	copy(result, pl)
-	copy(result[l:2*l], pl)
-	return result
-}
-
-func (pl *patternList) remove(p *pattern) {
-	(*pl) = pl.diff(patternList{p})
-}
-
 func (pl patternList) dictionary() map[string]any {
 	dict := make(map[string]any)
 	for _, a := range pl {
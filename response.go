@@ -0,0 +1,122 @@
+package docopt
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// responseFiles expands the response files (@file) of a command-line.
+type responseFiles struct {
+	p *Parser
+	// stack holds the names of the files being expanded, to detect cycles.
+	stack []string
+	// literal is true once "--" is seen: the rest is not expanded.
+	literal bool
+}
+
+// expandResponseFiles returns argv with each argument @file replaced by the
+// arguments contained in file, expanded in turn. The arguments after "--"
+// are not expanded.
+func (p *Parser) expandResponseFiles(argv []string) ([]string, error) {
+	rf := responseFiles{p: p}
+	return rf.expand(argv)
+}
+
+func (rf *responseFiles) expand(argv []string) ([]string, error) {
+	expanded := make([]string, 0, len(argv))
+	for _, arg := range argv {
+		if rf.literal || !strings.HasPrefix(arg, "@") || arg == "@" {
+			if arg == "--" {
+				rf.literal = true
+			}
+			expanded = append(expanded, arg)
+			continue
+		}
+		name := arg[1:]
+		if slices.Contains(rf.stack, name) {
+			return nil, &UserError{rf.p.message(MsgResponseFileLoop, name)}
+		}
+		data, err := os.ReadFile(name)
+		if err != nil {
+			var pathErr *fs.PathError
+			if errors.As(err, &pathErr) {
+				err = pathErr.Err
+			}
+			return nil, &UserError{rf.p.message(MsgResponseFile, name, err)}
+		}
+		args, err := rf.p.splitResponseFile(string(data))
+		if err != nil {
+			return nil, &UserError{rf.p.message(MsgResponseFile, name, err)}
+		}
+		rf.stack = append(rf.stack, name)
+		args, err = rf.expand(args)
+		if err != nil {
+			return nil, err
+		}
+		rf.stack = rf.stack[:len(rf.stack)-1]
+		expanded = append(expanded, args...)
+	}
+	return expanded, nil
+}
+
+// splitResponseFile splits the contents of a response file into arguments
+// separated by white space. Single quotes preserve the text up to the
+// closing quote; outside of them a backslash escapes the next character.
+// For example:
+//
+//	--name 'a b' "it's" c\ d
+//
+// gives the arguments: --name, a b, it's, c d.
+func (p *Parser) splitResponseFile(s string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false // to keep empty quoted arguments
+	var quote rune // the open quote, if any
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			arg.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\\':
+			escaped, inArg = true, true
+		case quote == '"':
+			if r == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New(p.message(MsgMissingQuote, quote))
+	}
+	if escaped {
+		return nil, errors.New(p.message(MsgBackslashAtEnd))
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
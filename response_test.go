@@ -0,0 +1,71 @@
+package docopt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-quicktest/qt"
+)
+
+func TestSplitResponseFile(t *testing.T) {
+	testCases := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{" \n\t", nil},
+		{"a b\nc", []string{"a", "b", "c"}},
+		{`--name 'a b' "it's" c\ d`, []string{"--name", "a b", "it's", "c d"}},
+		{`'' ""`, []string{"", ""}},
+		{`'a\b' "a\"b" a"b c"d`, []string{`a\b`, `a"b`, "ab cd"}},
+	}
+	for _, tc := range testCases {
+		args, err := testParser.splitResponseFile(tc.in)
+		qt.Assert(t, qt.IsNil(err), qt.Commentf("in: %q", tc.in))
+		qt.Assert(t, qt.DeepEquals(args, tc.want), qt.Commentf("in: %q", tc.in))
+	}
+
+	_, err := testParser.splitResponseFile(`a 'b`)
+	qt.Assert(t, qt.ErrorMatches(err, "missing closing quote '"))
+	_, err = testParser.splitResponseFile(`a b\`)
+	qt.Assert(t, qt.ErrorMatches(err, "backslash at end of file"))
+}
+
+func TestParseResponseFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, contents string) string {
+		path := filepath.Join(dir, name)
+		qt.Assert(t, qt.IsNil(os.WriteFile(path, []byte(contents), 0o600)))
+		return path
+	}
+	files := write("files.txt", "'a b.txt'\nc.txt\n")
+	args := write("args.txt", "-v\n@"+files+"\n")
+	verbose := write("verbose.txt", "-v")
+	loop := write("loop.txt", "@"+filepath.Join(dir, "loop.txt"))
+	doc := "Usage: prog [-v] [--] [<file>...]"
+	parser := &Parser{ResponseFiles: true}
+
+	v, err := parser.Parse(doc, []string{"@" + args, "d.txt"}, "")
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(v, Opts{"-v": true, "--": false, "<file>": []string{"a b.txt", "c.txt", "d.txt"}}))
+
+	// After "--", @name is a plain argument.
+	v, err = parser.Parse(doc, []string{"@" + verbose, "--", "@" + files}, "")
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(v, Opts{"-v": true, "--": true, "<file>": []string{"@" + files}}))
+
+	missing := filepath.Join(dir, "missing.txt")
+	_, err = parser.Parse(doc, []string{"@" + missing}, "")
+	qt.Assert(t, qt.ErrorAs(err, new(*UserError)))
+	qt.Assert(t, qt.ErrorMatches(err, "response file "+missing+": no such file or directory"))
+
+	_, err = parser.Parse(doc, []string{"@" + loop}, "")
+	qt.Assert(t, qt.ErrorAs(err, new(*UserError)))
+	qt.Assert(t, qt.ErrorMatches(err, "response file "+loop+": includes itself"))
+
+	// Disabled by default.
+	v, err = testParser.Parse(doc, []string{"@" + files}, "")
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(v, Opts{"-v": false, "--": false, "<file>": []string{"@" + files}}))
+}
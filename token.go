@@ -28,44 +28,120 @@ type tokenList struct {
 	tokens    []string
 	errorFunc func(string, ...any) error
 	err       errorType
+
+	// doc and offsets locate the tokens in the doc they come from, to report
+	// language errors with a position. offsets has one entry per token plus
+	// a final one for the end of the source. Both are empty if unknown.
+	doc     string
+	offsets []int
+	last    int // offset of the token last returned by move, or -1
 }
 type token string
 
 func newTokenList(source []string, err errorType) *tokenList {
-	errorFunc := fmt.Errorf
+	tl := &tokenList{tokens: source, err: err, last: -1}
+	tl.errorFunc = fmt.Errorf
 	if err == errorUser {
-		errorFunc = func(format string, a ...any) error {
+		tl.errorFunc = func(format string, a ...any) error {
 			return &UserError{fmt.Sprintf(format, a...)}
 		}
 	} else if err == errorLanguage {
-		errorFunc = func(format string, a ...any) error {
-			return &LanguageError{fmt.Sprintf(format, a...)}
+		tl.errorFunc = func(format string, a ...any) error {
+			return tl.errorAt(tl.last, format, a...)
 		}
 	}
-	return &tokenList{source, errorFunc, err}
+	return tl
 }
 
 func tokenListFromString(source string) *tokenList {
 	return newTokenList(strings.Fields(source), errorUser)
 }
 
-func tokenListFromPattern(source string) *tokenList {
-	p := regexp.MustCompile(`([\[\]\(\)\|]|\.\.\.)`)
-	source = p.ReplaceAllString(source, ` $1 `)
-	p = regexp.MustCompile(`\s+|(\S*<.*?>)`)
-	split := p.Split(source, -1)
-	match := p.FindAllStringSubmatch(source, -1)
-	var result []string
-	l := len(split)
-	for i := 0; i < l; i++ {
-		if len(split[i]) > 0 {
-			result = append(result, split[i])
+// The optional "]" keeps --name[=<arg>] as a single token.
+var rePatternSplit = regexp.MustCompile(`\s+|(\S*<.*?>\]?)`)
+
+// splitPattern splits a formal usage pattern into tokens, returning also the
+// offset of each token in source.
+func splitPattern(source string) ([]string, []int) {
+	// Surround the special tokens with spaces, remembering where each byte
+	// of the spaced string comes from (-1 for the added spaces).
+	var b strings.Builder
+	origin := make([]int, 0, len(source))
+	for i := 0; i < len(source); {
+		// Keep the optional argument of --name[=<arg>] with the option.
+		if end := strings.IndexByte(source[i:], ']'); strings.HasPrefix(source[i:], "[=") &&
+			i > 0 && !unicode.IsSpace(rune(source[i-1])) && end > 0 {
+			b.WriteString(source[i : i+end+1])
+			for j := 0; j <= end; j++ {
+				origin = append(origin, i+j)
+			}
+			i += end + 1
+			continue
+		}
+		m := ""
+		if strings.HasPrefix(source[i:], "...") {
+			m = "..."
+		} else if strings.IndexByte("[]()|", source[i]) >= 0 {
+			m = source[i : i+1]
 		}
-		if i < l-1 && len(match[i][1]) > 0 {
-			result = append(result, match[i][1])
+		if m != "" {
+			b.WriteString(" " + m + " ")
+			origin = append(origin, -1)
+			for j := range m {
+				origin = append(origin, i+j)
+			}
+			origin = append(origin, -1)
+			i += len(m)
+			continue
+		}
+		b.WriteByte(source[i])
+		origin = append(origin, i)
+		i++
+	}
+	spaced := b.String()
+
+	// Split on white space, keeping "<...>" arguments (that can contain
+	// spaces) as a single token.
+	var tokens []string
+	var offsets []int
+	add := func(start, end int) {
+		if start < end {
+			tokens = append(tokens, spaced[start:end])
+			offsets = append(offsets, origin[start])
 		}
 	}
-	return newTokenList(result, errorLanguage)
+	prev := 0
+	for _, m := range rePatternSplit.FindAllStringSubmatchIndex(spaced, -1) {
+		add(prev, m[0])
+		if m[2] >= 0 {
+			add(m[2], m[3])
+		}
+		prev = m[1]
+	}
+	add(prev, len(spaced))
+	return tokens, offsets
+}
+
+// errorAt returns an error built like errorFunc. If the tokens come from the
+// doc, a language error also reports the position of offset in the doc.
+func (tl *tokenList) errorAt(offset int, format string, a ...any) error {
+	if tl.err != errorLanguage {
+		return tl.errorFunc(format, a...)
+	}
+	err := &LanguageError{msg: fmt.Sprintf(format, a...)}
+	if tl.doc != "" {
+		err.locate(tl.doc, offset)
+	}
+	return err
+}
+
+// offset returns the offset in the doc of the current token (or of the end
+// of the source if there are no tokens left), or -1 if unknown.
+func (tl *tokenList) offset() int {
+	if len(tl.offsets) == 0 {
+		return -1
+	}
+	return tl.offsets[0]
 }
 
 func (t *token) eq(s string) bool {
@@ -129,6 +205,10 @@ func (tl *tokenList) move() *token {
 	if len(tl.tokens) > 0 {
 		t := tl.tokens[0]
 		tl.tokens = tl.tokens[1:]
+		if len(tl.offsets) > 0 {
+			tl.last = tl.offsets[0]
+			tl.offsets = tl.offsets[1:]
+		}
 		return (*token)(&t)
 	}
 	return nil
@@ -0,0 +1,99 @@
+package docopt
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime/debug"
+	"strings"
+)
+
+// VersionInfo is the version of a program, as printed by --version when
+// Parser.Version is set.
+type VersionInfo struct {
+	// Program is the name of the program. If empty, Parse sets it to the
+	// first word of the usage.
+	Program string `json:"program"`
+	// Module is the path of the main module, like "example.com/prog".
+	Module string `json:"module,omitempty"`
+	// Version is the version of the main module, like "v1.2.3", or
+	// "(devel)" when built from a working tree.
+	Version string `json:"version"`
+	// Revision is the version control revision the program was built from.
+	Revision string `json:"revision,omitempty"`
+	// Time is the time of the revision, in RFC 3339 format.
+	Time string `json:"time,omitempty"`
+	// Dirty is true if the working tree had local changes.
+	Dirty bool `json:"dirty,omitempty"`
+	// GoVersion is the version of the Go toolchain, like "go1.21.0".
+	GoVersion string `json:"go,omitempty"`
+}
+
+// ReadVersion returns the version of the running program from the build
+// information embedded by the Go toolchain; see runtime/debug.ReadBuildInfo.
+// It can be used as Parser.Version:
+//
+//	parser := &docopt.Parser{Version: docopt.ReadVersion}
+func ReadVersion() VersionInfo {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return VersionInfo{Version: "unknown"}
+	}
+	return versionFromBuildInfo(bi)
+}
+
+func versionFromBuildInfo(bi *debug.BuildInfo) VersionInfo {
+	v := VersionInfo{
+		Module:    bi.Main.Path,
+		Version:   bi.Main.Version,
+		GoVersion: bi.GoVersion,
+	}
+	if v.Version == "" {
+		v.Version = "unknown"
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			v.Revision = s.Value
+		case "vcs.time":
+			v.Time = s.Value
+		case "vcs.modified":
+			v.Dirty = s.Value == "true"
+		}
+	}
+	return v
+}
+
+// String returns the version in the standard format, for example:
+//
+//	prog v1.2.3 (revision 0123456789ab, dirty) go1.21.0
+func (v VersionInfo) String() string {
+	var b strings.Builder
+	b.WriteString(v.Program)
+	if b.Len() > 0 {
+		b.WriteString(" ")
+	}
+	b.WriteString(v.Version)
+	if v.Revision != "" {
+		fmt.Fprintf(&b, " (revision %.12s", v.Revision)
+		if v.Dirty {
+			b.WriteString(", dirty")
+		}
+		b.WriteString(")")
+	}
+	if v.GoVersion != "" {
+		b.WriteString(" " + v.GoVersion)
+	}
+	return b.String()
+}
+
+// JSON returns the version as a JSON object, for example:
+//
+//	{"program":"prog","version":"v1.2.3","revision":"0123...","go":"go1.21.0"}
+func (v VersionInfo) JSON() string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		// Cannot happen: all fields are strings and booleans.
+		panic(err)
+	}
+	return string(data)
+}
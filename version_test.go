@@ -0,0 +1,69 @@
+package docopt
+
+import (
+	"runtime/debug"
+	"strings"
+	"testing"
+
+	"github.com/go-quicktest/qt"
+)
+
+func TestVersionFromBuildInfo(t *testing.T) {
+	bi := &debug.BuildInfo{
+		GoVersion: "go1.21.0",
+		Main:      debug.Module{Path: "example.com/prog", Version: "v1.2.3"},
+		Settings: []debug.BuildSetting{
+			{Key: "vcs", Value: "git"},
+			{Key: "vcs.revision", Value: "0123456789abcdef0123456789abcdef01234567"},
+			{Key: "vcs.time", Value: "2023-01-02T03:04:05Z"},
+			{Key: "vcs.modified", Value: "true"},
+		},
+	}
+	v := versionFromBuildInfo(bi)
+	qt.Assert(t, qt.DeepEquals(v, VersionInfo{
+		Module:    "example.com/prog",
+		Version:   "v1.2.3",
+		Revision:  "0123456789abcdef0123456789abcdef01234567",
+		Time:      "2023-01-02T03:04:05Z",
+		Dirty:     true,
+		GoVersion: "go1.21.0",
+	}))
+
+	v.Program = "prog"
+	qt.Assert(t, qt.Equals(v.String(), "prog v1.2.3 (revision 0123456789ab, dirty) go1.21.0"))
+	qt.Assert(t, qt.Equals(v.JSON(), `{"program":"prog","module":"example.com/prog","version":"v1.2.3",`+
+		`"revision":"0123456789abcdef0123456789abcdef01234567","time":"2023-01-02T03:04:05Z","dirty":true,"go":"go1.21.0"}`))
+
+	qt.Assert(t, qt.Equals(VersionInfo{Version: "(devel)"}.String(), "(devel)"))
+}
+
+func TestParseVersion(t *testing.T) {
+	doc := "Usage: prog [--json] --version"
+	var stdout strings.Builder
+	parser := &Parser{
+		Version: func() VersionInfo { return VersionInfo{Version: "v1.0.0", GoVersion: "go1.21.0"} },
+		Stdout:  &stdout,
+	}
+
+	_, err := parser.Parse(doc, []string{"--version"}, "ignored")
+	qt.Assert(t, qt.ErrorIs(err, ErrHelp))
+	qt.Assert(t, qt.Equals(stdout.String(), "prog v1.0.0 go1.21.0\n"))
+
+	stdout.Reset()
+	_, err = parser.Parse(doc, []string{"--version", "--json"}, "")
+	qt.Assert(t, qt.ErrorIs(err, ErrHelp))
+	qt.Assert(t, qt.Equals(stdout.String(), `{"program":"prog","version":"v1.0.0","go":"go1.21.0"}`+"\n"))
+
+	// Not declared: --json does not change the version.
+	stdout.Reset()
+	_, err = parser.Parse("Usage: prog --version", []string{"--version", "--json"}, "")
+	qt.Assert(t, qt.ErrorIs(err, ErrHelp))
+	qt.Assert(t, qt.Equals(stdout.String(), "prog v1.0.0 go1.21.0\n"))
+
+	// Without a provider, the version argument as is.
+	stdout.Reset()
+	parser.Version = nil
+	_, err = parser.Parse(doc, []string{"--version", "--json"}, "1.0")
+	qt.Assert(t, qt.ErrorIs(err, ErrHelp))
+	qt.Assert(t, qt.Equals(stdout.String(), "1.0\n"))
+}